@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"flag"
+	"strings"
+	"time"
 
 	oidc_server "github.com/danicc097/oidc-server/v3"
 	"github.com/danicc097/oidc-server/v3/example/models"
 	"github.com/danicc097/oidc-server/v3/storage"
 	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"gopkg.in/square/go-jose.v2"
 )
 
 const (
@@ -65,12 +68,29 @@ func setUserInfoFunc(user *models.AuthServerUser, userInfo *oidc.UserInfo, scope
 }
 
 func main() {
-	var env, certFile, keyFile, pathPrefix string
+	var env, certFile, keyFile, pathPrefix, signingAlgorithm, mfaACRValues string
+	var keyRotationInterval, janitorInterval, authRequestLifetime, codeLifetime time.Duration
+	var accessTokenLifetime, refreshTokenLifetime, refreshTokenIdleLifetime, idTokenLifetime, sessionLifetime time.Duration
+	var persistState, loginHintAutoSubmit, showTOTPCode bool
 
 	flag.StringVar(&env, "env", ".env", "Environment Variables filename")
 	flag.StringVar(&pathPrefix, "path-prefix", "", "Domain path prefix. Example: /oidc")
 	flag.StringVar(&certFile, "cert-file", "", "TLS certificate filepath")
 	flag.StringVar(&keyFile, "key-file", "", "TLS certificate key filepath")
+	flag.StringVar(&signingAlgorithm, "signing-algorithm", "RS256", "Default token signing algorithm. One of: RS256, PS256, ES256")
+	flag.DurationVar(&keyRotationInterval, "key-rotation-interval", 0, "Signing key rotation interval. Example: 24h. Disabled if zero")
+	flag.DurationVar(&janitorInterval, "janitor-interval", time.Minute, "Interval expired tokens, codes and auth requests are purged at")
+	flag.DurationVar(&authRequestLifetime, "auth-request-lifetime", 30*time.Minute, "Time after which pending auth requests are purged")
+	flag.DurationVar(&codeLifetime, "code-lifetime", time.Minute, "Authorization code lifetime")
+	flag.DurationVar(&accessTokenLifetime, "access-token-lifetime", 5*time.Minute, "Default access token lifetime")
+	flag.DurationVar(&refreshTokenLifetime, "refresh-token-lifetime", 5*time.Hour, "Default absolute refresh token lifetime")
+	flag.DurationVar(&refreshTokenIdleLifetime, "refresh-token-idle-lifetime", 0, "Default time after which unused refresh tokens expire. Disabled if zero")
+	flag.DurationVar(&idTokenLifetime, "id-token-lifetime", time.Hour, "Default id_token lifetime")
+	flag.DurationVar(&sessionLifetime, "session-lifetime", 24*time.Hour, "Time users stay signed in to the server across clients")
+	flag.StringVar(&mfaACRValues, "mfa-acr-values", "", "Comma-separated acr values only achieved by signing in with a TOTP code")
+	flag.BoolVar(&loginHintAutoSubmit, "login-hint-auto-submit", false, "Sign in the user matching login_hint without showing the login page")
+	flag.BoolVar(&showTOTPCode, "show-totp-code", false, "Show the current code on the TOTP page of users with a totpSecret")
+	flag.BoolVar(&persistState, "persist-state", false, "Persist tokens and sessions in ${DATA_DIR}/state.db across restarts")
 
 	flag.Parse()
 
@@ -78,6 +98,23 @@ func main() {
 		SetUserInfoFunc:                setUserInfoFunc,
 		GetPrivateClaimsFromScopesFunc: getPrivateClaimsFromScopesFunc,
 		PathPrefix:                     pathPrefix,
+		SigningAlgorithm:               jose.SignatureAlgorithm(signingAlgorithm),
+		KeyRotationInterval:            keyRotationInterval,
+		JanitorInterval:                janitorInterval,
+		AuthRequestLifetime:            authRequestLifetime,
+		CodeLifetime:                   codeLifetime,
+		AccessTokenLifetime:            accessTokenLifetime,
+		RefreshTokenLifetime:           refreshTokenLifetime,
+		RefreshTokenIdleLifetime:       refreshTokenIdleLifetime,
+		IDTokenLifetime:                idTokenLifetime,
+		SessionLifetime:                sessionLifetime,
+		LoginHintAutoSubmit:            loginHintAutoSubmit,
+		ShowTOTPCode:                   showTOTPCode,
+		PersistState:                   persistState,
+	}
+
+	if mfaACRValues != "" {
+		config.MFAACRValues = strings.Split(mfaACRValues, ",")
 	}
 
 	if certFile != "" && keyFile != "" {
@@ -23,6 +23,12 @@ type AuthServerUser struct {
 	PhoneVerified     bool         `json:"phoneVerified"`
 	PreferredLanguage language.Tag `json:"preferredLanguage"`
 	IsAdmin_          bool         `json:"isAdmin"`
+	// ACRValues_ are the authentication context class references the user can satisfy,
+	// the first of which is used unless the client requests another one via acr_values.
+	ACRValues_ []string `json:"acrValues,omitempty"`
+	// TOTPSecret_ is the base32 encoded secret of the user's authenticator app,
+	// who then has to enter a code after the password.
+	TOTPSecret_ string `json:"totpSecret,omitempty"`
 }
 
 func (u AuthServerUser) ID() string {
@@ -41,4 +47,20 @@ func (u AuthServerUser) Password() string {
 	return u.Password_
 }
 
-var _ storage.User = (*AuthServerUser)(nil)
+func (u AuthServerUser) GetEmail() string {
+	return u.Email
+}
+
+func (u AuthServerUser) ACRValues() []string {
+	return u.ACRValues_
+}
+
+func (u AuthServerUser) TOTPSecret() string {
+	return u.TOTPSecret_
+}
+
+var (
+	_ storage.UserWithEmail = (*AuthServerUser)(nil)
+	_ storage.UserWithACR   = (*AuthServerUser)(nil)
+	_ storage.UserWithTOTP  = (*AuthServerUser)(nil)
+)
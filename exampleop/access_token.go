@@ -0,0 +1,79 @@
+package exampleop
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/danicc097/oidc-server/v3/storage"
+	"github.com/zitadel/oidc/v2/pkg/op"
+)
+
+type jwtAccessTokenStorage interface {
+	JWTAccessToken(ctx context.Context, issuer string) (string, bool, error)
+}
+
+// jwtAccessTokens serves the token endpoint and the auth callback (implicit flow) of the provider
+// with a Crypto signing the access tokens of clients using JWT access tokens with the typ header of RFC 9068,
+// which the library can't set (see storage.ContextWithJWTAccessTokens). Since the library creates the id_token
+// of the response with the result, its at_hash matches.
+// It is registered as interceptor of the provider, so provider must be set once it is created.
+type jwtAccessTokens struct {
+	provider *op.Provider
+	storage  jwtAccessTokenStorage
+}
+
+func (j *jwtAccessTokens) interceptor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenEndpoint := r.URL.Path == j.provider.TokenEndpoint().Relative()
+		if !tokenEndpoint && r.URL.Path != j.provider.AuthorizationEndpoint().Relative()+"/callback" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.WithContext(storage.ContextWithJWTAccessTokens(r.Context()))
+		provider := &jwtAccessTokenProvider{
+			Provider: j.provider,
+			crypto: &jwtAccessTokenCrypto{
+				Crypto:  j.provider.Crypto(),
+				ctx:     r.Context(),
+				issuer:  op.IssuerFromContext(r.Context()),
+				storage: j.storage,
+			},
+		}
+		if tokenEndpoint {
+			op.Exchange(w, r, provider)
+		} else {
+			op.AuthorizeCallback(w, r, provider)
+		}
+	})
+}
+
+type jwtAccessTokenProvider struct {
+	*op.Provider
+	crypto op.Crypto
+}
+
+func (p *jwtAccessTokenProvider) Crypto() op.Crypto {
+	return p.crypto
+}
+
+// jwtAccessTokenCrypto creates the bearer token of the access token issued during the request
+// as JWT access token if its client uses them. Everything else, e.g. codes, is encrypted as usual.
+type jwtAccessTokenCrypto struct {
+	op.Crypto
+	ctx     context.Context
+	issuer  string
+	storage jwtAccessTokenStorage
+}
+
+func (c *jwtAccessTokenCrypto) Encrypt(s string) (string, error) {
+	accessToken, ok, err := c.storage.JWTAccessToken(c.ctx, c.issuer)
+	if err != nil {
+		// a bearer token must never be issued in place of a JWT access token
+		return "", err
+	}
+	if !ok {
+		return c.Crypto.Encrypt(s)
+	}
+	return accessToken, nil
+}
@@ -0,0 +1,156 @@
+package exampleop
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/danicc097/oidc-server/v3/storage"
+	"github.com/gorilla/mux"
+	httphelper "github.com/zitadel/oidc/v2/pkg/http"
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
+)
+
+type adminStorage interface {
+	RotateSigningKeys(ctx context.Context) ([]op.Key, error)
+	Tokens(ctx context.Context, filter storage.EntryFilter) ([]storage.Token, error)
+	RefreshTokens(ctx context.Context, filter storage.EntryFilter) ([]storage.RefreshToken, error)
+	AuthRequests(ctx context.Context, filter storage.EntryFilter) ([]storage.AuthRequest, error)
+	RevokeAccessToken(ctx context.Context, id string) error
+	RevokeRefreshToken(ctx context.Context, id string) error
+	RevokeAuthRequest(ctx context.Context, id string) error
+	RevokeEntries(ctx context.Context, filter storage.EntryFilter) (storage.RevokedEntries, error)
+}
+
+type admin struct {
+	storage adminStorage
+	token   string
+}
+
+// registerAdmin registers the admin API, which requires the given token
+// as bearer token for every request.
+func registerAdmin(storage adminStorage, router *mux.Router, token string) {
+	a := &admin{
+		storage: storage,
+		token:   token,
+	}
+
+	router.Use(a.authMiddleware)
+	router.Path("/keys/rotate").Methods(http.MethodPost).HandlerFunc(a.rotateKeysHandler)
+
+	// stored entries can be filtered by ?subject= and ?client_id=
+	router.Path("/tokens").Methods(http.MethodGet).HandlerFunc(listHandler(a.storage.Tokens))
+	router.Path("/tokens/{id}").Methods(http.MethodDelete).HandlerFunc(a.revokeHandler(a.storage.RevokeAccessToken))
+	router.Path("/refresh-tokens").Methods(http.MethodGet).HandlerFunc(listHandler(a.storage.RefreshTokens))
+	router.Path("/refresh-tokens/{id}").Methods(http.MethodDelete).HandlerFunc(a.revokeHandler(a.storage.RevokeRefreshToken))
+	router.Path("/auth-requests").Methods(http.MethodGet).HandlerFunc(listHandler(a.storage.AuthRequests))
+	router.Path("/auth-requests/{id}").Methods(http.MethodDelete).HandlerFunc(a.revokeHandler(a.storage.RevokeAuthRequest))
+	router.Path("/users/{id}/tokens").Methods(http.MethodDelete).HandlerFunc(a.revokeUserHandler)
+}
+
+func (a *admin) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			adminError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of the Authorization header of r if it uses the Bearer scheme,
+// which is case-insensitive (RFC 7235 section 2.1).
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func (a *admin) rotateKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.storage.RotateSigningKeys(r.Context())
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// respond with the new public keys, same as the keys endpoint
+	keySet := &jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, len(keys))}
+	for i, key := range keys {
+		keySet.Keys[i] = jose.JSONWebKey{
+			KeyID:     key.ID(),
+			Algorithm: string(key.Algorithm()),
+			Use:       key.Use(),
+			Key:       key.Key(),
+		}
+	}
+	httphelper.MarshalJSON(w, keySet)
+}
+
+// listHandler responds with the entries returned by list for the filter in the query.
+func listHandler[E any](list func(ctx context.Context, filter storage.EntryFilter) ([]E, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := list(r.Context(), entryFilter(r))
+		if err != nil {
+			adminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		httphelper.MarshalJSON(w, entries)
+	}
+}
+
+// revokeHandler removes the entry with the id in the path using revoke.
+func (a *admin) revokeHandler(revoke func(ctx context.Context, id string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := revoke(r.Context(), mux.Vars(r)["id"])
+		if errors.Is(err, storage.ErrNotFound) {
+			adminError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			adminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// revokeUserHandler removes all tokens, refresh tokens and auth requests of a user,
+// optionally only for the client in ?client_id=.
+func (a *admin) revokeUserHandler(w http.ResponseWriter, r *http.Request) {
+	revoked, err := a.storage.RevokeEntries(r.Context(), storage.EntryFilter{
+		Subject:  mux.Vars(r)["id"],
+		ClientID: r.URL.Query().Get("client_id"),
+	})
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httphelper.MarshalJSON(w, revoked)
+}
+
+func entryFilter(r *http.Request) storage.EntryFilter {
+	return storage.EntryFilter{
+		Subject:  r.URL.Query().Get("subject"),
+		ClientID: r.URL.Query().Get("client_id"),
+	}
+}
+
+func adminError(w http.ResponseWriter, status int, msg string) {
+	httphelper.MarshalJSONWithStatus(w, struct {
+		Error string `json:"error"`
+	}{
+		Error: msg,
+	}, status)
+}
@@ -0,0 +1,130 @@
+package exampleop
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/danicc097/oidc-server/v3/storage"
+	"github.com/gorilla/mux"
+	httphelper "github.com/zitadel/oidc/v2/pkg/http"
+)
+
+type adminUsers[T storage.User] struct {
+	userStore storage.UserStore[T]
+}
+
+// registerAdminUsers registers the user management endpoints of the admin API.
+// The router is expected to be authenticated already (see registerAdmin).
+func registerAdminUsers[T storage.User](userStore storage.UserStore[T], router *mux.Router) {
+	a := &adminUsers[T]{
+		userStore: userStore,
+	}
+
+	router.Path("/users").Methods(http.MethodGet).HandlerFunc(a.listHandler)
+	router.Path("/users").Methods(http.MethodPost).HandlerFunc(a.createHandler)
+	router.Path("/users/{id}").Methods(http.MethodGet).HandlerFunc(a.getHandler)
+	router.Path("/users/{id}").Methods(http.MethodPut).HandlerFunc(a.updateHandler)
+	router.Path("/users/{id}").Methods(http.MethodDelete).HandlerFunc(a.deleteHandler)
+}
+
+func (a *adminUsers[T]) listHandler(w http.ResponseWriter, r *http.Request) {
+	users := make([]*T, 0)
+	for _, user := range a.userStore.Users() {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return (*users[i]).ID() < (*users[j]).ID()
+	})
+
+	httphelper.MarshalJSON(w, users)
+}
+
+func (a *adminUsers[T]) getHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.userStore.GetUserByID(mux.Vars(r)["id"])
+	if user == nil {
+		adminError(w, http.StatusNotFound, storage.ErrUserNotFound.Error())
+		return
+	}
+
+	httphelper.MarshalJSON(w, user)
+}
+
+func (a *adminUsers[T]) createHandler(w http.ResponseWriter, r *http.Request) {
+	user := new(T)
+	if err := json.NewDecoder(r.Body).Decode(user); err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userStore, ok := a.mutableUserStore(w)
+	if !ok {
+		return
+	}
+	if err := userStore.CreateUser(user); err != nil {
+		adminUserError(w, err)
+		return
+	}
+
+	httphelper.MarshalJSONWithStatus(w, user, http.StatusCreated)
+}
+
+func (a *adminUsers[T]) updateHandler(w http.ResponseWriter, r *http.Request) {
+	user := new(T)
+	if err := json.NewDecoder(r.Body).Decode(user); err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if (*user).ID() != mux.Vars(r)["id"] {
+		adminError(w, http.StatusBadRequest, "id does not match")
+		return
+	}
+
+	userStore, ok := a.mutableUserStore(w)
+	if !ok {
+		return
+	}
+	if err := userStore.UpdateUser(user); err != nil {
+		adminUserError(w, err)
+		return
+	}
+
+	httphelper.MarshalJSON(w, user)
+}
+
+func (a *adminUsers[T]) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	userStore, ok := a.mutableUserStore(w)
+	if !ok {
+		return
+	}
+	if err := userStore.DeleteUser(mux.Vars(r)["id"]); err != nil {
+		adminUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mutableUserStore returns the user store if it supports managing users,
+// responding with 501 Not Implemented otherwise.
+func (a *adminUsers[T]) mutableUserStore(w http.ResponseWriter) (storage.MutableUserStore[T], bool) {
+	userStore, ok := a.userStore.(storage.MutableUserStore[T])
+	if !ok {
+		adminError(w, http.StatusNotImplemented, "user store does not support managing users")
+	}
+	return userStore, ok
+}
+
+func adminUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		adminError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrUserExists):
+		adminError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, storage.ErrInvalidUser):
+		adminError(w, http.StatusBadRequest, err.Error())
+	default:
+		adminError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,100 @@
+package exampleop
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/danicc097/oidc-server/v3/storage"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"github.com/zitadel/oidc/v2/pkg/op"
+)
+
+const pathConsent = "/consent"
+
+type consentStorage interface {
+	GrantConsent(ctx context.Context, authRequestID string) error
+}
+
+type consent struct {
+	provider   *op.Provider
+	storage    Storage
+	pathPrefix string
+}
+
+// registerConsent registers the page users consent to the scopes requested by clients
+// which require consent on, after they authenticated.
+func registerConsent(provider *op.Provider, storage Storage, pathPrefix string, router *mux.Router) {
+	c := &consent{
+		provider:   provider,
+		storage:    storage,
+		pathPrefix: pathPrefix,
+	}
+
+	router.Path(pathConsent).Methods(http.MethodGet).HandlerFunc(c.consentHandler)
+	router.Path(pathConsent).Methods(http.MethodPost).HandlerFunc(c.checkConsentHandler)
+}
+
+func (c *consent) consentHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get(queryAuthRequestID)
+	authReq, err := c.pendingAuthRequest(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data := &struct {
+		ID         string
+		ClientID   string
+		Scopes     []string
+		PathPrefix string
+	}{
+		ID:         id,
+		ClientID:   authReq.GetClientID(),
+		Scopes:     authReq.GetScopes(),
+		PathPrefix: strings.TrimSuffix(c.pathPrefix, "/"),
+	}
+	if err := templates.ExecuteTemplate(w, "consent", data); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func (c *consent) checkConsentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("cannot parse form:%s", err), http.StatusInternalServerError)
+		return
+	}
+	id := r.FormValue("id")
+	authReq, err := c.pendingAuthRequest(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("action") != "allow" {
+		if err := c.storage.DeleteAuthRequest(r.Context(), id); err != nil {
+			logrus.Error(err)
+		}
+		op.AuthRequestError(w, r, authReq, oidc.ErrAccessDenied().WithDescription("The user denied consent."), c.provider.Encoder())
+		return
+	}
+	if err := c.storage.GrantConsent(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, c.pathPrefix+"/auth/callback?id="+id, http.StatusFound)
+}
+
+func (c *consent) pendingAuthRequest(ctx context.Context, id string) (op.AuthRequest, error) {
+	authReq, err := c.storage.AuthRequestByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if req, ok := authReq.(*storage.AuthRequest); !ok || !req.ConsentPending() {
+		return nil, fmt.Errorf("request is not pending consent")
+	}
+	return authReq, nil
+}
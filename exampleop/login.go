@@ -8,6 +8,8 @@ import (
 
 	"github.com/danicc097/oidc-server/v3/storage"
 	"github.com/gorilla/mux"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"github.com/zitadel/oidc/v2/pkg/op"
 )
 
 type login[T storage.User] struct {
@@ -15,15 +17,21 @@ type login[T storage.User] struct {
 	router       *mux.Router
 	callback     func(context.Context, string) string
 	pathPrefix   string
-	users        map[string]*T
+	userStore    storage.UserStore[T]
+	// autoSubmitLoginHint signs in the user matching the login_hint without showing the login page
+	autoSubmitLoginHint bool
+	// showTOTPCode shows the current code on the TOTP page
+	showTOTPCode bool
 }
 
-func NewLogin[T storage.User](authenticate authenticate, callback func(context.Context, string) string, pathPrefix string, users map[string]*T) *login[T] {
+func NewLogin[T storage.User](authenticate authenticate, callback func(context.Context, string) string, pathPrefix string, userStore storage.UserStore[T], autoSubmitLoginHint, showTOTPCode bool) *login[T] {
 	l := &login[T]{
-		authenticate: authenticate,
-		callback:     callback,
-		pathPrefix:   pathPrefix,
-		users:        users,
+		authenticate:        authenticate,
+		callback:            callback,
+		pathPrefix:          pathPrefix,
+		userStore:           userStore,
+		autoSubmitLoginHint: autoSubmitLoginHint,
+		showTOTPCode:        showTOTPCode,
 	}
 	l.createRouter()
 	return l
@@ -33,10 +41,18 @@ func (l *login[T]) createRouter() {
 	l.router = mux.NewRouter()
 	l.router.Path("/username").Methods("GET").HandlerFunc(l.loginHandler)
 	l.router.Path("/username").Methods("POST").HandlerFunc(l.checkLoginHandler)
+	l.router.Path("/select").Methods("POST").HandlerFunc(l.selectAccountHandler)
+	l.router.Path("/otp").Methods("GET").HandlerFunc(l.otpHandler)
+	l.router.Path("/otp").Methods("POST").HandlerFunc(l.checkOTPHandler)
 }
 
 type authenticate interface {
 	CheckUsernamePassword(username, password, id string) error
+	CheckTOTPCode(code, id string) error
+	CurrentTOTPCode(ctx context.Context, id string) (string, error)
+	AuthRequestByID(ctx context.Context, id string) (op.AuthRequest, error)
+	sessionStorage
+	consentStorage
 }
 
 func (l *login[T]) loginHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,10 +63,119 @@ func (l *login[T]) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// the oidc package will pass the id of the auth request as query parameter
 	// we will use this id through the login process and therefore pass it to the login page
-	l.renderLogin(w, r.FormValue(queryAuthRequestID), nil)
+	id := r.FormValue(queryAuthRequestID)
+
+	// requests of users who already signed in with this browser are completed right away,
+	// unless they are asked to choose the account (prompt=select_account), and those of users
+	// who entered their password continue with the TOTP page instead of signing in again
+	if authReq, err := l.authenticate.AuthRequestByID(r.Context(), id); err == nil {
+		req, ok := authReq.(*storage.AuthRequest)
+		if ok && (req.Done() || req.OTPPending() || req.ConsentPending()) {
+			l.redirectAuthenticated(w, r, req)
+			return
+		}
+		if ok && req.HasPrompt(oidc.PromptSelectAccount) && r.FormValue("add_account") == "" {
+			sessions, err := l.authenticate.Sessions(r.Context())
+			if err != nil {
+				l.renderLogin(w, r, id, err)
+				return
+			}
+			if len(sessions) > 0 {
+				l.renderSelectAccount(w, id, sessions)
+				return
+			}
+		}
+		if ok && l.autoSubmitLoginHint {
+			if _, user := storage.FindUserByLoginHint(l.userStore.Users(), req.LoginHint); user != nil {
+				l.signIn(w, r, id, (*user).Username(), (*user).Password())
+				return
+			}
+		}
+	}
+	l.renderLogin(w, r, id, nil)
+}
+
+// renderSelectAccount renders the account chooser, which lists the users signed in with the browser.
+func (l *login[T]) renderSelectAccount(w http.ResponseWriter, id string, sessions []*storage.Session) {
+	type account struct {
+		SessionID string
+		Username  string
+	}
+	data := &struct {
+		ID         string
+		PathPrefix string
+		Accounts   []account
+	}{
+		ID:         id,
+		PathPrefix: l.prefix(),
+	}
+	for _, session := range sessions {
+		user := l.userStore.GetUserByID(session.UserID)
+		if user == nil {
+			continue
+		}
+		data.Accounts = append(data.Accounts, account{
+			SessionID: session.ID,
+			Username:  (*user).Username(),
+		})
+	}
+	if err := templates.ExecuteTemplate(w, "select_account", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (l *login[T]) selectAccountHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot parse form:%s", err), http.StatusInternalServerError)
+		return
+	}
+	id := r.FormValue("id")
+	sessionID := r.FormValue("session")
+	if err := l.authenticate.SelectSession(r.Context(), id, sessionID); err != nil {
+		// e.g. the session is older than max_age, so the user has to sign in again
+		l.renderLogin(w, r, id, err)
+		return
+	}
+
+	// the chosen account becomes the active one of the browser
+	sessions, err := l.authenticate.Sessions(r.Context())
+	if err != nil {
+		l.renderLogin(w, r, id, err)
+		return
+	}
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			setSessionCookie(w, r, session, sessions)
+		}
+	}
+	l.redirectAuthenticatedByID(w, r, id)
+}
+
+func (l *login[T]) prefix() string {
+	if l.pathPrefix == "" {
+		return ""
+	}
+	return "/" + strings.TrimPrefix(strings.TrimSuffix(l.pathPrefix, "/"), "/")
+}
+
+// redirectAuthenticated continues with the TOTP page if the user has yet to enter a code,
+// the consent page if the user has yet to consent, or else with the callback.
+func (l *login[T]) redirectAuthenticated(w http.ResponseWriter, r *http.Request, authReq *storage.AuthRequest) {
+	if authReq.OTPPending() {
+		http.Redirect(w, r, l.pathPrefix+"/login/otp?"+queryAuthRequestID+"="+authReq.GetID(), http.StatusFound)
+		return
+	}
+	if authReq.ConsentPending() {
+		http.Redirect(w, r, l.pathPrefix+pathConsent+"?"+queryAuthRequestID+"="+authReq.GetID(), http.StatusFound)
+		return
+	}
+	// don't use l.callback, will remove issuer path prefix
+	http.Redirect(w, r, l.pathPrefix+"/auth/callback?id="+authReq.GetID(), http.StatusFound)
 }
 
-func (l *login[T]) renderLogin(w http.ResponseWriter, id string, err error) {
+// renderLogin renders the login page, preselecting the user matching the login_hint of the request, if any.
+func (l *login[T]) renderLogin(w http.ResponseWriter, r *http.Request, id string, err error) {
 	if len(storage.StorageErrors.Errors) > 0 {
 		errMsg := strings.Join(storage.StorageErrors.Errors, " | ")
 		fmt.Printf("storage error err: %v\n", errMsg)
@@ -58,20 +183,45 @@ func (l *login[T]) renderLogin(w http.ResponseWriter, id string, err error) {
 
 		return
 	}
-	prefix := ""
-	if l.pathPrefix != "" {
-		prefix = "/" + strings.TrimPrefix(strings.TrimSuffix(l.pathPrefix, "/"), "/")
+	// the page fills in the credentials of the selected user, so it must not get any other secrets, e.g. TOTP secrets
+	type loginUser struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+		HasTOTP  bool   `json:"hasTOTP"`
 	}
 	data := &struct {
 		ID         string
 		Error      string
 		PathPrefix string
-		Users      map[string]*T
+		Users      map[string]loginUser
+		Selected   string
 	}{
 		ID:         id,
-		PathPrefix: prefix,
+		PathPrefix: l.prefix(),
 		Error:      errMsg(err),
-		Users:      l.users,
+		Users:      make(map[string]loginUser),
+	}
+	users := l.userStore.Users()
+	for key, user := range users {
+		u := loginUser{
+			ID:       (*user).ID(),
+			Username: (*user).Username(),
+			Password: (*user).Password(),
+		}
+		if withEmail, ok := interface{}(*user).(storage.UserWithEmail); ok {
+			u.Email = withEmail.GetEmail()
+		}
+		if withTOTP, ok := interface{}(*user).(storage.UserWithTOTP); ok {
+			u.HasTOTP = withTOTP.TOTPSecret() != ""
+		}
+		data.Users[key] = u
+	}
+	if authReq, err := l.authenticate.AuthRequestByID(r.Context(), id); err == nil {
+		if req, ok := authReq.(*storage.AuthRequest); ok {
+			data.Selected, _ = storage.FindUserByLoginHint(users, req.LoginHint)
+		}
 	}
 	err = templates.ExecuteTemplate(w, "login", data)
 	if err != nil {
@@ -85,14 +235,106 @@ func (l *login[T]) checkLoginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("cannot parse form:%s", err), http.StatusInternalServerError)
 		return
 	}
-	username := r.FormValue("username")
-	password := r.FormValue("password")
+	l.signIn(w, r, r.FormValue("id"), r.FormValue("username"), r.FormValue("password"))
+}
+
+// signIn checks the credentials for the auth request and starts a session for the user.
+func (l *login[T]) signIn(w http.ResponseWriter, r *http.Request, id, username, password string) {
+	err := l.authenticate.CheckUsernamePassword(username, password, id)
+	if err != nil {
+		l.renderLogin(w, r, id, err)
+		return
+	}
+	l.startSession(w, r, id, l.renderLogin)
+}
+
+// otpHandler shows the TOTP page to users with a TOTP secret who entered their password.
+func (l *login[T]) otpHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot parse form:%s", err), http.StatusInternalServerError)
+		return
+	}
+	l.renderOTP(w, r, r.FormValue(queryAuthRequestID), nil)
+}
+
+// renderOTP renders the TOTP page, including the current code if showTOTPCode is set.
+func (l *login[T]) renderOTP(w http.ResponseWriter, r *http.Request, id string, err error) {
+	data := &struct {
+		ID         string
+		Error      string
+		PathPrefix string
+		Code       string
+	}{
+		ID:         id,
+		PathPrefix: l.prefix(),
+		Error:      errMsg(err),
+	}
+	if l.showTOTPCode {
+		code, err := l.authenticate.CurrentTOTPCode(r.Context(), id)
+		if err != nil {
+			data.Error = errMsg(err)
+		}
+		data.Code = code
+	}
+	if err := templates.ExecuteTemplate(w, "otp", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (l *login[T]) checkOTPHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot parse form:%s", err), http.StatusInternalServerError)
+		return
+	}
 	id := r.FormValue("id")
-	err = l.authenticate.CheckUsernamePassword(username, password, id)
+	if err := l.authenticate.CheckTOTPCode(r.FormValue("code"), id); err != nil {
+		l.renderOTP(w, r, id, err)
+		return
+	}
+	l.startSession(w, r, id, l.renderOTP)
+}
+
+// startSession starts a session for the user of the auth request once authenticated,
+// or continues with the TOTP page. Errors are rendered with renderError.
+func (l *login[T]) startSession(w http.ResponseWriter, r *http.Request, id string, renderError func(http.ResponseWriter, *http.Request, string, error)) {
+	authReq, err := l.authenticate.AuthRequestByID(r.Context(), id)
 	if err != nil {
-		l.renderLogin(w, id, err)
+		renderError(w, r, id, err)
 		return
 	}
-	// don't use l.callback, will remove issuer path prefix
-	http.Redirect(w, r, l.pathPrefix+"/auth/callback?id="+id, http.StatusFound)
+	if req, ok := authReq.(*storage.AuthRequest); ok && req.OTPPending() {
+		l.redirectAuthenticated(w, r, req)
+		return
+	}
+
+	// the new session becomes the active one, keeping the sessions of other users of the browser
+	session, err := l.authenticate.CreateSession(r.Context(), id)
+	if err != nil {
+		renderError(w, r, id, err)
+		return
+	}
+	sessions, err := l.authenticate.Sessions(r.Context())
+	if err != nil {
+		renderError(w, r, id, err)
+		return
+	}
+	setSessionCookie(w, r, session, sessions)
+
+	l.redirectAuthenticatedByID(w, r, id)
+}
+
+func (l *login[T]) redirectAuthenticatedByID(w http.ResponseWriter, r *http.Request, id string) {
+	authReq, err := l.authenticate.AuthRequestByID(r.Context(), id)
+	if err != nil {
+		l.renderLogin(w, r, id, err)
+		return
+	}
+	req, ok := authReq.(*storage.AuthRequest)
+	if !ok {
+		l.renderLogin(w, r, id, fmt.Errorf("unexpected auth request type %T", authReq))
+		return
+	}
+	l.redirectAuthenticated(w, r, req)
 }
@@ -0,0 +1,244 @@
+package exampleop
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"github.com/zitadel/oidc/v2/pkg/op"
+)
+
+// formLogoutConfirmed is submitted by the confirmation page of end_session requests without id_token_hint.
+const formLogoutConfirmed = "logout_confirmed"
+
+type logoutStorage interface {
+	FrontChannelLogoutURIs(ctx context.Context, userID string) ([]string, error)
+}
+
+type logout struct {
+	provider *op.Provider
+	storage  Storage
+}
+
+// registerLogout registers the end_session endpoint in place of the one of the provider,
+// and the page users are redirected to after signing out without a post_logout_redirect_uri.
+func registerLogout(provider *op.Provider, storage Storage, router *mux.Router) {
+	l := &logout{
+		provider: provider,
+		storage:  storage,
+	}
+
+	issuerInterceptor := op.NewIssuerInterceptor(provider.IssuerFromRequest)
+	router.Path(provider.EndSessionEndpoint().Relative()).HandlerFunc(issuerInterceptor.HandlerFunc(l.endSessionHandler))
+	router.Path(pathLoggedOut).Methods(http.MethodGet).HandlerFunc(l.loggedOutHandler)
+}
+
+func (l *logout) endSessionHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := op.ParseEndSessionRequest(r, l.provider.Decoder())
+	if err != nil {
+		op.RequestError(w, r, err)
+		return
+	}
+	session, err := l.validateEndSessionRequest(r.Context(), req)
+	if err != nil {
+		op.RequestError(w, r, err)
+		return
+	}
+	// without id_token_hint, the user of the browser session signs out once confirmed on a page, since anyone
+	// may send the browser here (OpenID Connect RP-Initiated Logout 1.0 section 2)
+	if session.UserID == "" {
+		active, err := l.storage.ActiveSession(r.Context())
+		if err != nil {
+			op.RequestError(w, r, oidc.DefaultToServerError(err, "error terminating session"))
+			return
+		}
+		if active != nil && r.PostFormValue(formLogoutConfirmed) == "" {
+			l.renderConfirmLogout(w, r, req)
+			return
+		}
+		if active != nil {
+			session.UserID = active.UserID
+		}
+	}
+	var frontChannelLogoutURIs []string
+	if session.UserID != "" {
+		frontChannelLogoutURIs, err = l.storage.FrontChannelLogoutURIs(r.Context(), session.UserID)
+		if err != nil {
+			op.RequestError(w, r, oidc.DefaultToServerError(err, "error terminating session"))
+			return
+		}
+	}
+	if err := l.storage.TerminateSession(r.Context(), session.UserID, session.ClientID); err != nil {
+		op.RequestError(w, r, oidc.DefaultToServerError(err, "error terminating session"))
+		return
+	}
+	// other users stay signed in with the browser
+	sessions, err := l.storage.Sessions(r.Context())
+	if err != nil {
+		op.RequestError(w, r, oidc.DefaultToServerError(err, "error terminating session"))
+		return
+	}
+	setSessionCookie(w, r, nil, sessions)
+
+	if len(frontChannelLogoutURIs) == 0 {
+		http.Redirect(w, r, session.RedirectURI, http.StatusFound)
+		return
+	}
+	// clients are notified via front-channel logout by a page loading their logout URIs in iframes,
+	// which continues to the redirect URI once all of them loaded
+	data := &struct {
+		LogoutURIs  []string
+		RedirectURI string
+	}{
+		LogoutURIs:  frontChannelLogoutURIs,
+		RedirectURI: session.RedirectURI,
+	}
+	if err := templates.ExecuteTemplate(w, "front_channel_logout", data); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// renderConfirmLogout renders the page asking the user whether to sign out, which submits the request again.
+func (l *logout) renderConfirmLogout(w http.ResponseWriter, r *http.Request, req *oidc.EndSessionRequest) {
+	data := &struct {
+		Action                string
+		ClientID              string
+		PostLogoutRedirectURI string
+		State                 string
+		ConfirmField          string
+	}{
+		Action:                l.provider.EndSessionEndpoint().Absolute(op.IssuerFromContext(r.Context())),
+		ClientID:              req.ClientID,
+		PostLogoutRedirectURI: req.PostLogoutRedirectURI,
+		State:                 req.State,
+		ConfirmField:          formLogoutConfirmed,
+	}
+	if err := templates.ExecuteTemplate(w, "confirm_logout", data); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// validateEndSessionRequest validates the request as op.ValidateEndSessionRequest does, except that
+// expired id_token_hints are accepted, since id_tokens commonly expire before users sign out.
+// Without a post_logout_redirect_uri, the user is redirected to the logged out page of the client.
+func (l *logout) validateEndSessionRequest(ctx context.Context, req *oidc.EndSessionRequest) (*op.EndSessionRequest, error) {
+	session := &op.EndSessionRequest{
+		ClientID: req.ClientID,
+	}
+	if req.IdTokenHint != "" {
+		claims, err := l.verifyIDTokenHint(ctx, req.IdTokenHint)
+		if err != nil {
+			return nil, oidc.ErrInvalidRequest().WithDescription("id_token_hint invalid").WithParent(err)
+		}
+		if req.ClientID != "" && req.ClientID != claims.AuthorizedParty {
+			return nil, oidc.ErrInvalidRequest().WithDescription("client_id does not match azp of id_token_hint")
+		}
+		session.UserID = claims.Subject
+		session.ClientID = claims.AuthorizedParty
+	}
+
+	query := url.Values{}
+	redirectURI := strings.TrimSuffix(op.IssuerFromContext(ctx), "/") + pathLoggedOut
+	if session.ClientID != "" {
+		client, err := l.storage.GetClientByClientID(ctx, session.ClientID)
+		if err != nil {
+			return nil, oidc.ErrInvalidRequest().WithDescription("client not found").WithParent(err)
+		}
+		if req.PostLogoutRedirectURI != "" {
+			if err := op.ValidateEndSessionPostLogoutRedirectURI(req.PostLogoutRedirectURI, client); err != nil {
+				return nil, err
+			}
+			redirectURI = req.PostLogoutRedirectURI
+		} else {
+			query.Set("client_id", session.ClientID)
+		}
+	} else if req.PostLogoutRedirectURI != "" {
+		return nil, oidc.ErrInvalidRequest().WithDescription("post_logout_redirect_uri requires client_id or id_token_hint")
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+
+	var err error
+	session.RedirectURI, err = withQuery(redirectURI, query)
+	if err != nil {
+		return nil, oidc.DefaultToServerError(err, "")
+	}
+	return session, nil
+}
+
+// verifyIDTokenHint verifies the issuer and signature of an id_token_hint, but not its expiration.
+func (l *logout) verifyIDTokenHint(ctx context.Context, token string) (*oidc.IDTokenClaims, error) {
+	verifier := l.provider.IDTokenHintVerifier(ctx)
+	claims := new(oidc.IDTokenClaims)
+	payload, err := oidc.ParseToken(token, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := oidc.CheckIssuer(claims, verifier.Issuer()); err != nil {
+		return nil, err
+	}
+	if err := oidc.CheckSignature(ctx, token, payload, claims, verifier.SupportedSignAlgs(), verifier.KeySet()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// loggedOutHandler renders the logged out page, linking back to the client in ?client_id=, if any.
+func (l *logout) loggedOutHandler(w http.ResponseWriter, r *http.Request) {
+	data := &struct {
+		ClientID  string
+		ClientURI string
+	}{
+		ClientID: r.URL.Query().Get("client_id"),
+	}
+	if data.ClientID != "" {
+		if client, err := l.storage.GetClientByClientID(r.Context(), data.ClientID); err == nil {
+			data.ClientURI = clientURI(client, r.URL.Query().Get("state"))
+		}
+	}
+
+	if err := templates.ExecuteTemplate(w, "logged_out", data); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// clientURI returns the first post logout redirect URI of the client including the state,
+// or else the origin of its first redirect URI.
+func clientURI(client op.Client, state string) string {
+	if uris := client.PostLogoutRedirectURIs(); len(uris) > 0 {
+		query := url.Values{}
+		if state != "" {
+			query.Set("state", state)
+		}
+		uri, err := withQuery(uris[0], query)
+		if err != nil {
+			return ""
+		}
+		return uri
+	}
+	if uris := client.RedirectURIs(); len(uris) > 0 {
+		if u, err := url.Parse(uris[0]); err == nil && u.Scheme != "" && u.Host != "" {
+			return u.Scheme + "://" + u.Host + "/"
+		}
+	}
+	return ""
+}
+
+// withQuery adds the query parameters to the URI, keeping existing ones.
+func withQuery(uri string, query url.Values) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -1,10 +1,13 @@
 package exampleop
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -13,18 +16,46 @@ import (
 	"golang.org/x/text/language"
 
 	"github.com/zitadel/logging"
+	httphelper "github.com/zitadel/oidc/v2/pkg/http"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
 	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
 )
 
 const (
 	// prefix        = "/oidc/" handled by traefik.
-	pathLoggedOut = "logged-out"
+	pathLoggedOut = "/logged-out"
 )
 
 type Storage interface {
 	op.Storage
 	authenticate
 	deviceAuthenticate
+	adminStorage
+	registrationStorage
+	jwtAccessTokenStorage
+	logoutStorage
+	discoveryStorage
+}
+
+// Config defines optional server behaviour.
+type Config struct {
+	// AdminToken enables the /admin API, authenticated with the given bearer token.
+	// The admin API is disabled if empty.
+	AdminToken string
+
+	// InitialAccessToken is required as bearer token for dynamic client registration.
+	// Anyone may register clients if empty.
+	InitialAccessToken string
+
+	// LoginHintAutoSubmit signs in the user matching the login_hint of authorization requests
+	// by ID, username or email without showing the login page, e.g. for end-to-end tests.
+	// Otherwise the user is only preselected.
+	LoginHintAutoSubmit bool
+
+	// ShowTOTPCode shows and fills in the current code on the TOTP page of users with a TOTP secret,
+	// so that multi-factor logins can be tested without an authenticator app.
+	ShowTOTPCode bool
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -40,7 +71,7 @@ func loggingMiddleware(next http.Handler) http.Handler {
 // SetupServer creates an OIDC server with Issuer=http://localhost:<port>
 //
 // Use one of the pre-made clients in storage/clients.go or register a new one.
-func SetupServer[T storage.User](issuer string, storage Storage, pathPrefix string, users map[string]*T, extraOptions ...op.Option) *mux.Router {
+func SetupServer[T storage.User](issuer string, storage Storage, pathPrefix string, userStore storage.UserStore[T], config Config, extraOptions ...op.Option) *mux.Router {
 	// the OpenID Provider requires a 32-byte key for (token) encryption
 	// be sure to create a proper crypto random key and manage it securely!
 	key := sha256.Sum256([]byte("test"))
@@ -52,23 +83,18 @@ func SetupServer[T storage.User](issuer string, storage Storage, pathPrefix stri
 	})
 	// subrouter := router.PathPrefix(prefix).Subrouter()
 	router.Use(loggingMiddleware)
-
-	// for simplicity, we provide a very small default page for users who have signed out
-	router.HandleFunc(pathLoggedOut, func(w http.ResponseWriter, req *http.Request) {
-		_, err := w.Write([]byte("signed out successfully"))
-		if err != nil {
-			log.Printf("error serving logged out page: %v", err)
-		}
-	})
+	router.Use(sessionMiddleware)
 
 	// creation of the OpenIDProvider with the just created in-memory Storage
-	provider, err := newOP(storage, issuer, key, extraOptions...)
+	jwtAccessTokens := &jwtAccessTokens{storage: storage}
+	provider, err := newOP(storage, issuer, key, append(extraOptions, op.WithHttpInterceptors(jwtAccessTokens.interceptor))...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	jwtAccessTokens.provider = provider
 	// the provider will only take care of the OpenID Protocol, so there must be some sort of UI for the login process
 	// for the simplicity of the example this means a simple page with username and password field
-	l := NewLogin(storage, op.AuthCallbackURL(provider), pathPrefix, users)
+	l := NewLogin(storage, op.AuthCallbackURL(provider), pathPrefix, userStore, config.LoginHintAutoSubmit, config.ShowTOTPCode)
 
 	// regardless of how many pages / steps there are in the process, the UI must be registered in the router,
 	// so we will direct all calls to /login to the login UI
@@ -77,24 +103,123 @@ func SetupServer[T storage.User](issuer string, storage Storage, pathPrefix stri
 	router.PathPrefix("/device").Subrouter()
 	registerDeviceAuth(storage, router.PathPrefix("/device").Subrouter())
 
+	registerRegistration(storage, router, provider.IssuerFromRequest, config.InitialAccessToken)
+
+	router.Path(oidc.DiscoveryEndpoint).Methods(http.MethodGet).HandlerFunc(discoveryHandler(provider, storage))
+
+	registerConsent(provider, storage, pathPrefix, router)
+
+	// users are redirected to a small default page after signing out without a post_logout_redirect_uri
+	registerLogout(provider, storage, router)
+
+	if config.AdminToken != "" {
+		adminRouter := router.PathPrefix("/admin").Subrouter()
+		registerAdmin(storage, adminRouter, config.AdminToken)
+		registerAdminUsers(userStore, adminRouter)
+	}
+
 	// we register the http handler of the OP on the root, so that the discovery endpoint (/.well-known/openid-configuration)
 	// is served on the correct path
 	//
 	// if your issuer ends with a path (e.g. http://localhost:9998/custom/path/),
 	// then you would have to set the path prefix (/custom/path/)
-	router.PathPrefix("/").Handler(provider.HttpHandler())
+	router.PathPrefix("/").Handler(clientContextMiddleware(provider, storage)(provider.HttpHandler()))
 
 	return router
 }
 
+// clientContextMiddleware stores the client a token or auth callback request is made for in the request context,
+// since client specific settings like the signing algorithm are otherwise unknown to the storage
+// when tokens are created, as well as the code_verifier of token requests, so that the storage can
+// check codes before consuming them.
+func clientContextMiddleware(provider op.OpenIDProvider, s op.Storage) func(http.Handler) http.Handler {
+	tokenPath := provider.TokenEndpoint().Relative()
+	callbackPath := provider.AuthorizationEndpoint().Relative() + "/callback"
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var clientID string
+			switch r.URL.Path {
+			case tokenPath:
+				if id, _, ok := r.BasicAuth(); ok {
+					clientID, _ = url.QueryUnescape(id)
+				} else if clientID = r.FormValue("client_id"); clientID == "" {
+					clientID = clientAssertionIssuer(r.FormValue("client_assertion"))
+				}
+				r = r.WithContext(storage.ContextWithCodeVerifier(r.Context(), r.FormValue("code_verifier")))
+			case callbackPath:
+				if authReq, err := s.AuthRequestByID(r.Context(), r.URL.Query().Get("id")); err == nil {
+					clientID = authReq.GetClientID()
+				}
+			}
+			if clientID != "" {
+				r = r.WithContext(storage.ContextWithClientID(r.Context(), clientID))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientAssertionIssuer returns the client a private_key_jwt client_assertion is issued by, without
+// verifying it, which the library does before the client is relied upon.
+func clientAssertionIssuer(assertion string) string {
+	if assertion == "" {
+		return ""
+	}
+	jws, err := jose.ParseSigned(assertion)
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(jws.UnsafePayloadWithoutVerification(), &claims); err != nil {
+		return ""
+	}
+	return claims.Issuer
+}
+
+// discoveryConfiguration adds the metadata of logout mechanisms the library doesn't support.
+type discoveryConfiguration struct {
+	*oidc.DiscoveryConfiguration
+	BackChannelLogoutSupported         bool `json:"backchannel_logout_supported"`
+	BackChannelLogoutSessionSupported  bool `json:"backchannel_logout_session_supported"`
+	FrontChannelLogoutSupported        bool `json:"frontchannel_logout_supported"`
+	FrontChannelLogoutSessionSupported bool `json:"frontchannel_logout_session_supported"`
+}
+
+type discoveryStorage interface {
+	op.Storage
+	ACRValuesSupported(ctx context.Context) []string
+}
+
+// discoveryHandler serves the discovery document of the provider,
+// extended with the endpoints and features the library doesn't know about.
+func discoveryHandler(provider op.OpenIDProvider, s discoveryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := op.CreateDiscoveryConfig(r, provider, s)
+		config.RegistrationEndpoint = strings.TrimSuffix(config.Issuer, "/") + pathRegister
+		config.ACRValuesSupported = s.ACRValuesSupported(r.Context())
+		httphelper.MarshalJSON(w, &discoveryConfiguration{
+			DiscoveryConfiguration:             config,
+			BackChannelLogoutSupported:         true,
+			BackChannelLogoutSessionSupported:  true,
+			FrontChannelLogoutSupported:        true,
+			FrontChannelLogoutSessionSupported: true,
+		})
+	}
+}
+
 // newOP will create an OpenID Provider for localhost on a specified port with a given encryption key
 // and a predefined default logout uri
 // it will enable all options (see descriptions)
-func newOP(storage op.Storage, issuer string, key [32]byte, extraOptions ...op.Option) (op.OpenIDProvider, error) {
+func newOP(s op.Storage, issuer string, key [32]byte, extraOptions ...op.Option) (*op.Provider, error) {
 	config := &op.Config{
 		CryptoKey: key,
 
 		// will be used if the end_session endpoint is called without a post_logout_redirect_uri
+		// (see registerLogout, which replaces the end_session endpoint)
 		DefaultLogoutRedirectURI: pathLoggedOut,
 
 		// enables code_challenge_method S256 for PKCE (and therefore PKCE in general)
@@ -122,10 +247,17 @@ func newOP(storage op.Storage, issuer string, key [32]byte, extraOptions ...op.O
 			UserCode:     op.UserCodeBase20,
 		},
 	}
-	handler, err := op.NewOpenIDProvider(issuer, config, storage,
+	supportedAlgs := make([]string, len(storage.SupportedSigningAlgorithms))
+	for i, alg := range storage.SupportedSigningAlgorithms {
+		supportedAlgs[i] = string(alg)
+	}
+	handler, err := op.NewOpenIDProvider(issuer, config, s,
 		append([]op.Option{
 			// we must explicitly allow the use of the http issuer
 			op.WithAllowInsecure(),
+			// tokens may be signed with any of the supported algorithms depending on the client
+			op.WithAccessTokenVerifierOpts(op.WithSupportedAccessTokenSigningAlgorithms(supportedAlgs...)),
+			op.WithIDTokenHintVerifierOpts(op.WithSupportedIDTokenHintSigningAlgorithms(supportedAlgs...)),
 			// as an example on how to customize an endpoint this will change the authorization_endpoint from /authorize to /auth
 			op.WithCustomAuthEndpoint(op.NewEndpoint("auth")),
 		}, extraOptions...)...,
@@ -0,0 +1,270 @@
+package exampleop
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danicc097/oidc-server/v3/storage"
+	"github.com/gorilla/mux"
+	httphelper "github.com/zitadel/oidc/v2/pkg/http"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const pathRegister = "/register"
+
+type registrationStorage interface {
+	RegisterClient(ctx context.Context, config storage.ClientConfig) (*storage.Client, error)
+	RegisteredClient(ctx context.Context, clientID, registrationAccessToken string) (*storage.Client, error)
+	UpdateClient(ctx context.Context, clientID, registrationAccessToken string, config storage.ClientConfig) (*storage.Client, error)
+	DeleteClient(ctx context.Context, clientID string) error
+}
+
+// clientMetadata is the client metadata defined in RFC 7591 section 2
+// and OpenID Connect Dynamic Client Registration 1.0 that is supported by the server.
+type clientMetadata struct {
+	RedirectURIs                      []string                `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod           oidc.AuthMethod         `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes                        []oidc.GrantType        `json:"grant_types,omitempty"`
+	ResponseTypes                     []oidc.ResponseType     `json:"response_types,omitempty"`
+	ApplicationType                   *op.ApplicationType     `json:"application_type,omitempty"`
+	PostLogoutRedirectURIs            []string                `json:"post_logout_redirect_uris,omitempty"`
+	IDTokenSignedResponseAlg          jose.SignatureAlgorithm `json:"id_token_signed_response_alg,omitempty"`
+	BackChannelLogoutURI              string                  `json:"backchannel_logout_uri,omitempty"`
+	FrontChannelLogoutURI             string                  `json:"frontchannel_logout_uri,omitempty"`
+	FrontChannelLogoutSessionRequired bool                    `json:"frontchannel_logout_session_required,omitempty"`
+}
+
+// clientUpdateRequest is the client update request defined in RFC 7592 section 2.2.
+type clientUpdateRequest struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	clientMetadata
+}
+
+// clientInformation is the successful registration response defined in RFC 7591 section 3.2.1,
+// including the client management fields of RFC 7592 section 3.
+type clientInformation struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token"`
+	RegistrationClientURI   string `json:"registration_client_uri"`
+	clientMetadata
+}
+
+type registration struct {
+	storage            registrationStorage
+	issuerFromRequest  op.IssuerFromRequest
+	initialAccessToken string
+}
+
+// registerRegistration registers the dynamic client registration endpoint and
+// the client configuration endpoint of registered clients.
+// If initialAccessToken is not empty, it is required as bearer token to register clients.
+func registerRegistration(storage registrationStorage, router *mux.Router, issuerFromRequest op.IssuerFromRequest, initialAccessToken string) {
+	reg := &registration{
+		storage:            storage,
+		issuerFromRequest:  issuerFromRequest,
+		initialAccessToken: initialAccessToken,
+	}
+
+	router.Path(pathRegister).Methods(http.MethodPost).HandlerFunc(reg.registerHandler)
+	router.Path(pathRegister + "/{client_id}").Methods(http.MethodGet).HandlerFunc(reg.clientHandler(reg.readClientHandler))
+	router.Path(pathRegister + "/{client_id}").Methods(http.MethodPut).HandlerFunc(reg.clientHandler(reg.updateClientHandler))
+	router.Path(pathRegister + "/{client_id}").Methods(http.MethodDelete).HandlerFunc(reg.clientHandler(reg.deleteClientHandler))
+}
+
+func (reg *registration) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if reg.initialAccessToken != "" {
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(reg.initialAccessToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			registrationError(w, http.StatusUnauthorized, "invalid_token", "invalid initial access token")
+			return
+		}
+	}
+
+	var metadata clientMetadata
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", err.Error())
+		return
+	}
+	config, errCode, err := metadata.clientConfig()
+	if err != nil {
+		registrationError(w, http.StatusBadRequest, errCode, err.Error())
+		return
+	}
+
+	client, err := reg.storage.RegisterClient(r.Context(), config)
+	if err != nil {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", err.Error())
+		return
+	}
+
+	info := reg.clientInformation(r, client)
+	info.ClientIDIssuedAt = time.Now().Unix()
+	httphelper.MarshalJSONWithStatus(w, info, http.StatusCreated)
+}
+
+// clientHandler authenticates client configuration requests with the registration access token.
+// Unknown clients are treated as invalid tokens, as required by RFC 7592 section 2.
+func (reg *registration) clientHandler(next func(w http.ResponseWriter, r *http.Request, client *storage.Client)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the registration access token is compared in constant time by RegisteredClient
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			registrationError(w, http.StatusUnauthorized, "invalid_token", "missing registration access token")
+			return
+		}
+		client, err := reg.storage.RegisteredClient(r.Context(), mux.Vars(r)["client_id"], token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			registrationError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+
+		next(w, r, client)
+	}
+}
+
+func (reg *registration) readClientHandler(w http.ResponseWriter, r *http.Request, client *storage.Client) {
+	httphelper.MarshalJSON(w, reg.clientInformation(r, client))
+}
+
+func (reg *registration) updateClientHandler(w http.ResponseWriter, r *http.Request, client *storage.Client) {
+	var req clientUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", err.Error())
+		return
+	}
+	if req.ClientID != client.GetID() {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", "client_id does not match")
+		return
+	}
+	if req.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(req.ClientSecret), []byte(client.Secret())) != 1 {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", "client_secret does not match")
+		return
+	}
+	config, errCode, err := req.clientConfig()
+	if err != nil {
+		registrationError(w, http.StatusBadRequest, errCode, err.Error())
+		return
+	}
+
+	client, err = reg.storage.UpdateClient(r.Context(), client.GetID(), client.RegistrationAccessToken(), config)
+	if err != nil {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", err.Error())
+		return
+	}
+
+	httphelper.MarshalJSON(w, reg.clientInformation(r, client))
+}
+
+func (reg *registration) deleteClientHandler(w http.ResponseWriter, r *http.Request, client *storage.Client) {
+	if err := reg.storage.DeleteClient(r.Context(), client.GetID()); err != nil {
+		registrationError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientConfig validates the metadata and applies the defaults of RFC 7591 section 2.
+// The error code to respond with is returned alongside any error.
+func (m clientMetadata) clientConfig() (storage.ClientConfig, string, error) {
+	config := storage.ClientConfig{
+		ApplicationType:                   op.ApplicationTypeWeb,
+		AuthMethod:                        m.TokenEndpointAuthMethod,
+		GrantTypes:                        m.GrantTypes,
+		ResponseTypes:                     m.ResponseTypes,
+		AccessTokenType:                   op.AccessTokenTypeBearer,
+		RedirectURIs:                      m.RedirectURIs,
+		PostLogoutRedirectURIs:            m.PostLogoutRedirectURIs,
+		IDTokenSignedResponseAlg:          m.IDTokenSignedResponseAlg,
+		BackChannelLogoutURI:              m.BackChannelLogoutURI,
+		FrontChannelLogoutURI:             m.FrontChannelLogoutURI,
+		FrontChannelLogoutSessionRequired: m.FrontChannelLogoutSessionRequired,
+	}
+	if m.ApplicationType != nil {
+		config.ApplicationType = *m.ApplicationType
+	}
+	if config.AuthMethod == "" {
+		config.AuthMethod = oidc.AuthMethodBasic
+	}
+	if len(config.GrantTypes) == 0 {
+		config.GrantTypes = []oidc.GrantType{oidc.GrantTypeCode}
+	}
+	if len(config.ResponseTypes) == 0 {
+		config.ResponseTypes = []oidc.ResponseType{oidc.ResponseTypeCode}
+	}
+
+	for _, grantType := range config.GrantTypes {
+		if grantType == oidc.GrantTypeCode || grantType == oidc.GrantTypeImplicit {
+			if len(config.RedirectURIs) == 0 {
+				return config, "invalid_redirect_uri", fmt.Errorf("redirect_uris are required for grant type %s", grantType)
+			}
+		}
+	}
+	for _, uri := range append(config.RedirectURIs, config.PostLogoutRedirectURIs...) {
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+			return config, "invalid_redirect_uri", fmt.Errorf("invalid redirect URI: %s", uri)
+		}
+	}
+
+	if config.BackChannelLogoutURI != "" {
+		if u, err := url.Parse(config.BackChannelLogoutURI); err != nil || !u.IsAbs() || u.Fragment != "" {
+			return config, "invalid_client_metadata", fmt.Errorf("invalid backchannel_logout_uri: %s", config.BackChannelLogoutURI)
+		}
+	}
+	if config.FrontChannelLogoutURI != "" {
+		if u, err := url.Parse(config.FrontChannelLogoutURI); err != nil || !u.IsAbs() || u.Fragment != "" {
+			return config, "invalid_client_metadata", fmt.Errorf("invalid frontchannel_logout_uri: %s", config.FrontChannelLogoutURI)
+		}
+	}
+
+	return config, "", nil
+}
+
+func (reg *registration) clientInformation(r *http.Request, client *storage.Client) clientInformation {
+	applicationType := client.ApplicationType()
+	return clientInformation{
+		ClientID:                client.GetID(),
+		ClientSecret:            client.Secret(),
+		ClientSecretExpiresAt:   0, // never expires
+		RegistrationAccessToken: client.RegistrationAccessToken(),
+		RegistrationClientURI:   strings.TrimSuffix(reg.issuerFromRequest(r), "/") + pathRegister + "/" + url.PathEscape(client.GetID()),
+		clientMetadata: clientMetadata{
+			RedirectURIs:                      client.RedirectURIs(),
+			TokenEndpointAuthMethod:           client.AuthMethod(),
+			GrantTypes:                        client.GrantTypes(),
+			ResponseTypes:                     client.ResponseTypes(),
+			ApplicationType:                   &applicationType,
+			PostLogoutRedirectURIs:            client.PostLogoutRedirectURIs(),
+			IDTokenSignedResponseAlg:          client.IDTokenSignedResponseAlg(),
+			BackChannelLogoutURI:              client.BackChannelLogoutURI(),
+			FrontChannelLogoutURI:             client.FrontChannelLogoutURI(),
+			FrontChannelLogoutSessionRequired: client.FrontChannelLogoutSessionRequired(),
+		},
+	}
+}
+
+// registrationError writes an error response as defined in RFC 7591 section 3.2.2.
+func registrationError(w http.ResponseWriter, status int, errCode, description string) {
+	httphelper.MarshalJSONWithStatus(w, struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}{
+		Error:            errCode,
+		ErrorDescription: description,
+	}, status)
+}
@@ -0,0 +1,77 @@
+package exampleop
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danicc097/oidc-server/v3/storage"
+)
+
+// sessionCookieName is the cookie identifying the single sign-on sessions of a browser,
+// as IDs separated by sessionCookieSeparator, starting with the active one.
+const (
+	sessionCookieName      = "oidc_session"
+	sessionCookieSeparator = "."
+)
+
+type sessionStorage interface {
+	CreateSession(ctx context.Context, authRequestID string) (*storage.Session, error)
+	SelectSession(ctx context.Context, authRequestID, sessionID string) error
+	Sessions(ctx context.Context) ([]*storage.Session, error)
+	ActiveSession(ctx context.Context) (*storage.Session, error)
+}
+
+// sessionMiddleware passes the sessions of the browser on to the storage, so that users
+// who already signed in don't have to do so again for other clients.
+func sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+			sessionIDs := strings.Split(cookie.Value, sessionCookieSeparator)
+			r = r.WithContext(storage.ContextWithSessionIDs(r.Context(), sessionIDs))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setSessionCookie stores the sessions of the browser, with active first if set.
+// The cookie is removed if there are no sessions left.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, active *storage.Session, sessions []*storage.Session) {
+	if active != nil {
+		sessions = append([]*storage.Session{active}, sessions...)
+	}
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		Secure:   isSecureRequest(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	seen := map[string]bool{}
+	var sessionIDs []string
+	for _, session := range sessions {
+		if seen[session.ID] {
+			continue
+		}
+		seen[session.ID] = true
+		sessionIDs = append(sessionIDs, session.ID)
+		if session.Expiration.After(cookie.Expires) {
+			cookie.Expires = session.Expiration
+		}
+	}
+	if len(sessionIDs) == 0 {
+		cookie.Expires = time.Unix(0, 0)
+		cookie.MaxAge = -1
+	}
+	cookie.Value = strings.Join(sessionIDs, sessionCookieSeparator)
+
+	http.SetCookie(w, cookie)
+}
+
+// isSecureRequest reports whether the request was made via HTTPS, possibly terminated by a proxy,
+// so that the session cookie is only sent over HTTPS where it is available.
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
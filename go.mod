@@ -10,6 +10,7 @@ require (
 	github.com/sirupsen/logrus v1.9.3
 	github.com/zitadel/logging v0.3.4
 	github.com/zitadel/oidc/v2 v2.6.3
+	go.etcd.io/bbolt v1.3.9
 	golang.org/x/text v0.9.0
 	gopkg.in/square/go-jose.v2 v2.6.0
 )
@@ -9,11 +9,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/danicc097/oidc-server/v3/exampleop"
 	"github.com/danicc097/oidc-server/v3/storage"
+	"gopkg.in/square/go-jose.v2"
 )
 
 // Config defines OIDC server configuration.
@@ -86,31 +90,89 @@ type Config[T storage.User] struct {
 
 	// PathPrefix represents domain subdirectories for the base URL, if any.
 	PathPrefix string
+
+	// SigningAlgorithm is the default algorithm tokens are signed with.
+	// See storage.WithSigningAlgorithm.
+	SigningAlgorithm jose.SignatureAlgorithm
+
+	// KeyRotationInterval and KeyRotationGracePeriod configure signing key rotation.
+	// See storage.WithKeyRotation.
+	KeyRotationInterval    time.Duration
+	KeyRotationGracePeriod time.Duration
+
+	// JanitorInterval is how often expired tokens, refresh tokens, codes, device authorizations
+	// and abandoned auth requests are purged. Default: 1m.
+	JanitorInterval time.Duration
+
+	// AuthRequestLifetime is how long auth requests may stay pending before they are purged.
+	// Default: 30m.
+	AuthRequestLifetime time.Duration
+
+	// CodeLifetime is how long authorization codes can be exchanged for tokens. Default: 60s.
+	CodeLifetime time.Duration
+
+	// AccessTokenLifetime is the default lifetime of access tokens. Default: 5m.
+	AccessTokenLifetime time.Duration
+
+	// RefreshTokenLifetime is the default absolute lifetime of refresh tokens,
+	// which is kept when they are renewed. Default: 5h.
+	RefreshTokenLifetime time.Duration
+
+	// RefreshTokenIdleLifetime is the default time after which unused refresh tokens expire.
+	// Disabled if zero.
+	RefreshTokenIdleLifetime time.Duration
+
+	// IDTokenLifetime is the default lifetime of id_tokens. Default: 1h.
+	IDTokenLifetime time.Duration
+
+	// SessionLifetime is how long users stay signed in to the server. See storage.WithSessionLifetime.
+	SessionLifetime time.Duration
+
+	// MFAACRValues are the acr values only achieved by signing in with a TOTP code. See storage.WithMFAACRValues.
+	MFAACRValues []string
+
+	// LoginHintAutoSubmit skips the login page for users matching the login_hint.
+	// See exampleop.Config.
+	LoginHintAutoSubmit bool
+
+	// ShowTOTPCode shows the current code on the TOTP page. See exampleop.Config.
+	ShowTOTPCode bool
+
+	// PersistState keeps tokens, refresh tokens, auth requests, codes, device authorizations, sessions
+	// and consent grants in ${DATA_DIR}/state.db instead of memory, so that sessions survive restarts.
+	PersistState bool
 }
 
 // Runs starts the OIDC server.
 func Run[T storage.User](config Config[T]) {
-	redirectURIsPath := path.Join(os.Getenv("DATA_DIR"), "redirect_uris.txt")
-	content, err := os.ReadFile(redirectURIsPath)
-	if err != nil {
-		panic(fmt.Errorf("could not read %s: %w", redirectURIsPath, err))
+	if config.PathPrefix != "" {
+		log.Default().Printf("Using domain path prefix: %v\n", config.PathPrefix)
 	}
 
-	redirectURIs := strings.Split(string(content), "\n")
+	storageOpts := []storage.Option{}
 
-	log.Default().Printf("Redirect URIs: %s\n", redirectURIs)
+	clientsDataDir := path.Join(os.Getenv("DATA_DIR"), "clients")
+	if _, err := os.Stat(clientsDataDir); err == nil {
+		log.Default().Printf("Loading clients from: %s\n", clientsDataDir)
+		storageOpts = append(storageOpts, storage.WithClientsDir(clientsDataDir))
+	} else {
+		// fall back to the default clients sharing the same redirect URIs
+		redirectURIsPath := path.Join(os.Getenv("DATA_DIR"), "redirect_uris.txt")
+		content, err := os.ReadFile(redirectURIsPath)
+		if err != nil {
+			panic(fmt.Errorf("could not read %s: %w", redirectURIsPath, err))
+		}
 
-	if config.PathPrefix != "" {
-		log.Default().Printf("Using domain path prefix: %v\n", config.PathPrefix)
-	}
+		redirectURIs := strings.Split(string(content), "\n")
 
-	storage.RegisterClients(
-		storage.NativeClient("native", config.PathPrefix, redirectURIs...),
-		storage.WebClient("web", "secret", config.PathPrefix, redirectURIs...),
-		storage.WebClient("api", "secret", config.PathPrefix, redirectURIs...),
-	)
+		log.Default().Printf("Redirect URIs: %s\n", redirectURIs)
 
-	ctx := context.Background()
+		storage.RegisterClients(
+			storage.NativeClient("native", config.PathPrefix, redirectURIs...),
+			storage.WebClient("web", "secret", config.PathPrefix, redirectURIs...),
+			storage.WebClient("api", "secret", config.PathPrefix, redirectURIs...),
+		)
+	}
 
 	issuer := os.Getenv("ISSUER")
 	port := os.Getenv("PORT")
@@ -124,22 +186,93 @@ func Run[T storage.User](config Config[T]) {
 		log.Fatal("could not create user store: ", err)
 	}
 
-	storage := storage.NewStorage(us, config.SetUserInfoFunc, config.GetPrivateClaimsFromScopesFunc)
+	keysDataDir := path.Join(os.Getenv("DATA_DIR"), "keys")
+
+	storageOpts = append(storageOpts,
+		storage.WithPathPrefix(config.PathPrefix),
+		storage.WithKeysDir(keysDataDir),
+		storage.WithSigningAlgorithm(config.SigningAlgorithm),
+		storage.WithKeyRotation(config.KeyRotationInterval, config.KeyRotationGracePeriod),
+		storage.WithJanitor(config.JanitorInterval, config.AuthRequestLifetime),
+		storage.WithCodeLifetime(config.CodeLifetime),
+		storage.WithTokenLifetimes(storage.TokenLifetimes{
+			AccessToken:      config.AccessTokenLifetime,
+			RefreshToken:     config.RefreshTokenLifetime,
+			RefreshTokenIdle: config.RefreshTokenIdleLifetime,
+			IDToken:          config.IDTokenLifetime,
+		}),
+		storage.WithSessionLifetime(config.SessionLifetime),
+		storage.WithMFAACRValues(config.MFAACRValues...),
+	)
 
-	router := exampleop.SetupServer(issuer, storage, config.PathPrefix, us.Users())
+	if err := serve(issuer, port, us, config, storageOpts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve runs the server until it receives SIGINT or SIGTERM, stopping the background tasks of the storage
+// and closing it once the server has shut down.
+func serve[T storage.User](issuer, port string, us storage.UserStore[T], config Config[T], storageOpts []storage.Option) error {
+	var backend storage.Backend
+	if config.PersistState {
+		statePath := path.Join(os.Getenv("DATA_DIR"), "state.db")
+		log.Default().Printf("Persisting state in: %s\n", statePath)
+		var err error
+		backend, err = storage.NewBoltBackend(statePath)
+		if err != nil {
+			return fmt.Errorf("could not create state backend: %w", err)
+		}
+		storageOpts = append(storageOpts, storage.WithBackend(backend))
+	}
+
+	storage, err := storage.NewStorage(us, config.SetUserInfoFunc, config.GetPrivateClaimsFromScopesFunc, storageOpts...)
+	if err != nil {
+		if backend != nil {
+			backend.Close()
+		}
+		return fmt.Errorf("could not create storage: %w", err)
+	}
+	defer storage.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	storage.StartKeyRotation(ctx)
+	storage.StartJanitor(ctx)
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Default().Printf("ADMIN_TOKEN not set: admin API disabled\n")
+	}
+
+	router := exampleop.SetupServer(issuer, storage, config.PathPrefix, us, exampleop.Config{
+		AdminToken:          adminToken,
+		InitialAccessToken:  os.Getenv("INITIAL_ACCESS_TOKEN"),
+		LoginHintAutoSubmit: config.LoginHintAutoSubmit,
+		ShowTOTPCode:        config.ShowTOTPCode,
+	})
 
 	server := &http.Server{
 		Addr:    ":" + port,
 		Handler: router,
 	}
-	log.Default().Printf("listening at: %s", server.Addr)
-	if config.TLS == nil {
-		err = server.ListenAndServe()
-	} else {
-		err = server.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile)
-	}
-	if err != nil {
-		log.Fatal(err)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Default().Printf("listening at: %s", server.Addr)
+		if config.TLS == nil {
+			serveErr <- server.ListenAndServe()
+		} else {
+			serveErr <- server.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
 	}
-	<-ctx.Done()
+	log.Default().Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
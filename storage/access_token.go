@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"github.com/zitadel/oidc/v2/pkg/op"
+)
+
+// jwtAccessTokenType is the typ header of JWT access tokens (RFC 9068 section 2.1).
+const jwtAccessTokenType = "at+jwt"
+
+// issuedAccessToken is the access token issued during a request (see ContextWithJWTAccessTokens).
+type issuedAccessToken struct {
+	tokenID string
+}
+
+// ContextWithJWTAccessTokens returns a copy of ctx for a request, e.g. to the token endpoint, whose access token
+// is to be signed by JWTAccessToken if its client uses JWT access tokens.
+//
+// The library signs JWT access tokens without the typ header required by RFC 9068, so for requests with such
+// a context clients using JWT access tokens are reported to it as bearer token clients (see GetClientByClientID)
+// and the bearer token is replaced with the result of JWTAccessToken, e.g. by the op.Crypto of the request.
+func ContextWithJWTAccessTokens(ctx context.Context) context.Context {
+	return context.WithValue(ctx, issuedAccessTokenContextKey, &issuedAccessToken{})
+}
+
+func issuedAccessTokenFromContext(ctx context.Context) *issuedAccessToken {
+	issued, _ := ctx.Value(issuedAccessTokenContextKey).(*issuedAccessToken)
+	return issued
+}
+
+// recordAccessToken keeps the ID of the access token issued during the request of ctx, if any,
+// for JWTAccessToken.
+func recordAccessToken(ctx context.Context, tokenID string) {
+	if issued := issuedAccessTokenFromContext(ctx); issued != nil {
+		issued.tokenID = tokenID
+	}
+}
+
+// JWTAccessToken returns the access token issued during the request of ctx (see ContextWithJWTAccessTokens)
+// as JWT access token following RFC 9068, if its client uses JWT access tokens. ok is false otherwise,
+// so that the bearer token of the library is issued. Each issued token is returned once.
+func (s *Storage[T]) JWTAccessToken(ctx context.Context, issuer string) (accessToken string, ok bool, err error) {
+	issued := issuedAccessTokenFromContext(ctx)
+	if issued == nil || issued.tokenID == "" {
+		return "", false, nil
+	}
+	tokenID := issued.tokenID
+	issued.tokenID = ""
+
+	token, err := func() (*Token, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.tokens.get(tokenID)
+	}()
+	if errors.Is(err, ErrNotFound) {
+		return "", false, fmt.Errorf("access token %s not found", tokenID)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	// tokens of the client credentials grant aren't issued for an application
+	clientID := token.ApplicationID
+	if clientID == "" {
+		clientID = clientIDFromContext(ctx)
+	}
+	client, found := getClient(clientID)
+	if !found || client.accessTokenType != op.AccessTokenTypeJWT {
+		return "", false, nil
+	}
+
+	claims := oidc.NewAccessTokenClaims(issuer, token.Subject, token.Audience, token.Expiration, token.ID, client.id, client.clockSkew)
+	claims.ClientID = client.id
+	claims.Scopes = token.Scopes
+	claims.AuthenticationContextClassReference = token.ACR
+	claims.Claims, err = s.getPrivateClaimsFromScopes(ctx, token.Subject, client.id, token.Scopes)
+	if err != nil {
+		return "", false, err
+	}
+
+	accessToken, err = s.keys.sign(claims, client.idTokenSignedResponseAlg, jwtAccessTokenType)
+	if err != nil {
+		return "", false, err
+	}
+	return accessToken, true, nil
+}
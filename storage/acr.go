@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/zitadel/oidc/v2/pkg/op"
+)
+
+// UserWithACR is implemented by users who declare the authentication context class references
+// (acr) they can satisfy when signing in, e.g. in order of increasing assurance.
+type UserWithACR interface {
+	User
+	ACRValues() []string
+}
+
+// userACRValues returns the acr values declared by the user, if any.
+func userACRValues[T User](user *T) []string {
+	if user == nil {
+		return nil
+	}
+	withACR, ok := interface{}(*user).(UserWithACR)
+	if !ok {
+		return nil
+	}
+	return withACR.ACRValues()
+}
+
+// achievedACR returns the acr the user achieves for the acr_values requested, in order of preference:
+// the first one the user can satisfy or, if none is requested or satisfiable, the first one the user declares.
+// It is empty for users who declare none.
+func achievedACR(declared, requested []string) string {
+	for _, acr := range requested {
+		if containsString(declared, acr) {
+			return acr
+		}
+	}
+	if len(declared) > 0 {
+		return declared[0]
+	}
+	return ""
+}
+
+// satisfiedACRValues returns the acr values declared by the user which are satisfied by signing in
+// with the given authentication methods: those set via WithMFAACRValues require a TOTP code ("otp").
+func (s *Storage[T]) satisfiedACRValues(userID string, amr []string) []string {
+	var values []string
+	for _, acr := range userACRValues(s.userStore.GetUserByID(userID)) {
+		if containsString(s.mfaACRValues, acr) && !containsString(amr, "otp") {
+			continue
+		}
+		values = append(values, acr)
+	}
+	return values
+}
+
+// acrFor returns the acr the user achieves by signing in for the auth request with the given methods.
+func (s *Storage[T]) acrFor(request *AuthRequest, userID string, amr []string) string {
+	return achievedACR(s.satisfiedACRValues(userID, amr), request.ACRValues)
+}
+
+// loginAMR returns the methods the user signs in with: the password and, if the user has a TOTP secret, a TOTP code.
+func (s *Storage[T]) loginAMR(userID string) []string {
+	if userTOTPSecret(s.userStore.GetUserByID(userID)) != "" {
+		return amrMFA
+	}
+	return []string{"pwd"}
+}
+
+// sessionSatisfiesACR reports whether the acr of the session satisfies the acr_values of the request,
+// or signing in again wouldn't achieve a better match.
+func (s *Storage[T]) sessionSatisfiesACR(request *AuthRequest, session *Session) bool {
+	if len(request.ACRValues) == 0 || containsString(request.ACRValues, session.ACR) {
+		return true
+	}
+	return !containsString(request.ACRValues, s.acrFor(request, session.UserID, s.loginAMR(session.UserID)))
+}
+
+// WithMFAACRValues sets the acr values which are only achieved by signing in with a second factor,
+// i.e. a TOTP code, even if the user declares them. A password-only login achieves another one the user declares, if any.
+func WithMFAACRValues(acrValues ...string) Option {
+	return func(o *options) {
+		o.mfaACRValues = acrValues
+	}
+}
+
+// ACRValuesSupported returns the acr values declared by any user, for discovery.
+func (s *Storage[T]) ACRValuesSupported(ctx context.Context) []string {
+	seen := map[string]bool{}
+	var values []string
+	for _, user := range s.userStore.Users() {
+		for _, acr := range userACRValues(user) {
+			if !seen[acr] {
+				seen[acr] = true
+				values = append(values, acr)
+			}
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// acrFromRequest returns the acr achieved for the auth request tokens for the request originate from,
+// which is kept across refresh token requests.
+func acrFromRequest(req op.TokenRequest) string {
+	switch req := req.(type) {
+	case *AuthRequest:
+		return req.acr
+	case *RefreshTokenRequest:
+		return req.ACR
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+)
+
+// enterTOTPCode completes the request pending the TOTP code with the current one.
+func enterTOTPCode(t *testing.T, s *Storage[testUser], ctx context.Context, request *AuthRequest) *AuthRequest {
+	t.Helper()
+	code, err := s.CurrentTOTPCode(ctx, request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckTOTPCode(code, request.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	request, err = s.authRequests.get(request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestACRFromAMR(t *testing.T) {
+	s := newTestStorage(t, WithMFAACRValues(testMFAACR))
+	clientID := "acr-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	req := testAuthRequest(clientID)
+	req.ACRValues = []string{testMFAACR}
+
+	// alice has no TOTP secret: her password doesn't achieve the MFA level she declares
+	passwordOnly := signIn(t, s, ctx, req, "alice")
+	if !passwordOnly.Done() {
+		t.Fatal("password-only login is not done")
+	}
+	if got := passwordOnly.GetACR(); got != testACR {
+		t.Errorf("password-only acr = %q, want %q", got, testACR)
+	}
+
+	withOTP := enterTOTPCode(t, s, ctx, signIn(t, s, ctx, req, "bob"))
+	if got := withOTP.GetACR(); got != testMFAACR {
+		t.Errorf("acr with otp = %q, want %q", got, testMFAACR)
+	}
+}
+
+func TestSessionACR(t *testing.T) {
+	s := newTestStorage(t, WithMFAACRValues(testMFAACR))
+	clientID := "session-acr-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	// both sign in without asking for an acr, achieving the first one they declare
+	_, aliceSession := startSession(t, s, ctx, clientID, "alice")
+	bob := enterTOTPCode(t, s, ctx, signIn(t, s, ctx, testAuthRequest(clientID), "bob"))
+	session, err := s.CreateSession(ctx, bob.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobSession := ContextWithSessionIDs(ctx, []string{session.ID})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		acrValues []string
+		prompt    oidc.SpaceDelimitedArray
+		wantErr   bool
+		wantDone  bool
+		wantACR   string
+	}{
+		{name: "acr of the session", ctx: bobSession, acrValues: []string{testACR}, wantDone: true, wantACR: testACR},
+		{name: "acr the user can achieve by signing in again", ctx: bobSession, acrValues: []string{testMFAACR}},
+		{name: "acr the user can achieve by signing in again with prompt=none", ctx: bobSession, acrValues: []string{testMFAACR}, prompt: oidc.SpaceDelimitedArray{oidc.PromptNone}, wantErr: true},
+		{name: "acr the user can't achieve", ctx: aliceSession, acrValues: []string{testMFAACR}, wantDone: true, wantACR: testACR},
+		{name: "unknown acr", ctx: bobSession, acrValues: []string{"urn:example:unknown"}, wantDone: true, wantACR: testACR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testAuthRequest(clientID)
+			req.ACRValues = tt.acrValues
+			req.Prompt = tt.prompt
+			authReq, err := s.CreateAuthRequest(tt.ctx, req, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateAuthRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if authReq.Done() != tt.wantDone {
+				t.Fatalf("Done() = %v, want %v", authReq.Done(), tt.wantDone)
+			}
+			if tt.wantDone && authReq.GetACR() != tt.wantACR {
+				t.Errorf("acr = %q, want %q", authReq.GetACR(), tt.wantACR)
+			}
+		})
+	}
+}
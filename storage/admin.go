@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"context"
+	"sort"
+)
+
+// EntryFilter filters stored entries by user and client. Empty fields match any entry.
+type EntryFilter struct {
+	Subject  string
+	ClientID string
+}
+
+func (f EntryFilter) matches(subject, clientID string) bool {
+	return (f.Subject == "" || f.Subject == subject) && (f.ClientID == "" || f.ClientID == clientID)
+}
+
+// RevokedEntries reports the number of entries removed by RevokeEntries.
+type RevokedEntries struct {
+	Tokens        int `json:"tokens"`
+	RefreshTokens int `json:"refreshTokens"`
+	AuthRequests  int `json:"authRequests"`
+}
+
+// Tokens returns the access tokens matching the filter, ordered by expiration.
+func (s *Storage[T]) Tokens(ctx context.Context, filter EntryFilter) ([]Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	all, err := s.tokens.all()
+	if err != nil {
+		return nil, err
+	}
+	tokens := []Token{}
+	for _, token := range all {
+		if filter.matches(token.Subject, token.ApplicationID) {
+			tokens = append(tokens, *token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Expiration.Before(tokens[j].Expiration)
+	})
+
+	return tokens, nil
+}
+
+// RefreshTokens returns the refresh tokens matching the filter, ordered by expiration.
+func (s *Storage[T]) RefreshTokens(ctx context.Context, filter EntryFilter) ([]RefreshToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	all, err := s.refreshTokens.all()
+	if err != nil {
+		return nil, err
+	}
+	refreshTokens := []RefreshToken{}
+	for _, refreshToken := range all {
+		if filter.matches(refreshToken.UserID, refreshToken.ApplicationID) {
+			refreshTokens = append(refreshTokens, *refreshToken)
+		}
+	}
+	sort.Slice(refreshTokens, func(i, j int) bool {
+		return refreshTokens[i].Expiration.Before(refreshTokens[j].Expiration)
+	})
+
+	return refreshTokens, nil
+}
+
+// AuthRequests returns the pending auth requests matching the filter, ordered by creation date.
+// Auth requests have no subject until the user is authenticated.
+func (s *Storage[T]) AuthRequests(ctx context.Context, filter EntryFilter) ([]AuthRequest, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	all, err := s.authRequests.all()
+	if err != nil {
+		return nil, err
+	}
+	authRequests := []AuthRequest{}
+	for _, authReq := range all {
+		if filter.matches(authReq.UserID, authReq.ApplicationID) {
+			authRequests = append(authRequests, *authReq)
+		}
+	}
+	sort.Slice(authRequests, func(i, j int) bool {
+		return authRequests[i].CreationDate.Before(authRequests[j].CreationDate)
+	})
+
+	return authRequests, nil
+}
+
+// RevokeAccessToken removes the access token with the given ID.
+func (s *Storage[T]) RevokeAccessToken(ctx context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.tokens.get(id); err != nil {
+		return err
+	}
+	return s.tokens.delete(id)
+}
+
+// RevokeRefreshToken removes the refresh token with the given ID
+// and the access tokens issued alongside it.
+func (s *Storage[T]) RevokeRefreshToken(ctx context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.refreshTokens.get(id); err != nil {
+		return err
+	}
+	_, err := s.revokeRefreshToken(id)
+	return err
+}
+
+// RevokeAuthRequest removes the auth request with the given ID and its code, if any,
+// so that the flow can't be completed.
+func (s *Storage[T]) RevokeAuthRequest(ctx context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.authRequests.get(id); err != nil {
+		return err
+	}
+	return s.revokeAuthRequest(id)
+}
+
+// RevokeEntries removes all tokens, refresh tokens and auth requests matching the filter,
+// e.g. everything belonging to a user.
+func (s *Storage[T]) RevokeEntries(ctx context.Context, filter EntryFilter) (RevokedEntries, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var revoked RevokedEntries
+	refreshTokens, err := s.refreshTokens.all()
+	if err != nil {
+		return revoked, err
+	}
+	for id, refreshToken := range refreshTokens {
+		if filter.matches(refreshToken.UserID, refreshToken.ApplicationID) {
+			n, err := s.revokeRefreshToken(id)
+			if err != nil {
+				return revoked, err
+			}
+			revoked.Tokens += n
+			revoked.RefreshTokens++
+		}
+	}
+	n, err := s.tokens.deleteWhere(func(_ string, token *Token) bool {
+		return filter.matches(token.Subject, token.ApplicationID)
+	})
+	revoked.Tokens += n
+	if err != nil {
+		return revoked, err
+	}
+	authRequests, err := s.authRequests.all()
+	if err != nil {
+		return revoked, err
+	}
+	for id, authReq := range authRequests {
+		if filter.matches(authReq.UserID, authReq.ApplicationID) {
+			if err := s.revokeAuthRequest(id); err != nil {
+				return revoked, err
+			}
+			revoked.AuthRequests++
+		}
+	}
+
+	return revoked, nil
+}
+
+// revokeRefreshToken removes the refresh token and its access tokens,
+// returning the number of access tokens removed.
+// s.lock must be held.
+func (s *Storage[T]) revokeRefreshToken(id string) (int, error) {
+	if err := s.refreshTokens.delete(id); err != nil {
+		return 0, err
+	}
+	return s.tokens.deleteWhere(func(_ string, token *Token) bool {
+		return token.RefreshTokenID == id
+	})
+}
+
+// revokeAuthRequest removes the auth request and its code.
+// s.lock must be held.
+func (s *Storage[T]) revokeAuthRequest(id string) error {
+	if err := s.authRequests.delete(id); err != nil {
+		return err
+	}
+	_, err := s.codes.deleteWhere(func(_ string, authCode *authCode) bool {
+		return authCode.RequestID == id
+	})
+	return err
+}
+
+// revokeRefreshTokenFamily removes all refresh tokens of a family and their access tokens.
+// s.lock must be held.
+func (s *Storage[T]) revokeRefreshTokenFamily(familyID string) (RevokedEntries, error) {
+	var revoked RevokedEntries
+	family := make(map[string]bool)
+	n, err := s.refreshTokens.deleteWhere(func(id string, refreshToken *RefreshToken) bool {
+		if refreshToken.FamilyID != familyID {
+			return false
+		}
+		family[id] = true
+		return true
+	})
+	revoked.RefreshTokens = n
+	if err != nil {
+		return revoked, err
+	}
+	revoked.Tokens, err = s.tokens.deleteWhere(func(_ string, token *Token) bool {
+		return family[token.RefreshTokenID]
+	})
+	return revoked, err
+}
+
+// revokeAuthRequestTokens removes all tokens and refresh tokens issued from the code of an auth request.
+// s.lock must be held.
+func (s *Storage[T]) revokeAuthRequestTokens(id string) (RevokedEntries, error) {
+	var revoked RevokedEntries
+	var err error
+	revoked.RefreshTokens, err = s.refreshTokens.deleteWhere(func(_ string, refreshToken *RefreshToken) bool {
+		return refreshToken.AuthRequestID == id
+	})
+	if err != nil {
+		return revoked, err
+	}
+	revoked.Tokens, err = s.tokens.deleteWhere(func(_ string, token *Token) bool {
+		return token.AuthRequestID == id
+	})
+	return revoked, err
+}
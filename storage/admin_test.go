@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// issuedEntries are the entries of a user signed in to a client.
+type issuedEntries struct {
+	subject, clientID                      string
+	authRequestID, tokenID, refreshTokenID string
+}
+
+// newRevocationStorage creates a storage in which alice is signed in to two clients and bob to one of them,
+// each with an auth request, a code, an access token and a refresh token, and a request of the first client is pending.
+func newRevocationStorage(t *testing.T) (*Storage[testUser], []issuedEntries, string) {
+	t.Helper()
+	s := newTestStorage(t)
+	clientID := "revoke-client"
+	otherClientID := "revoke-other-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	registerTestClient(t, WebClient(otherClientID, "secret", "", testRedirectURI))
+
+	var entries []issuedEntries
+	for _, signedIn := range []struct{ clientID, username string }{
+		{clientID, "alice"},
+		{otherClientID, "alice"},
+		{clientID, "bob"},
+	} {
+		ctx := ContextWithClientID(context.Background(), signedIn.clientID)
+		request := signIn(t, s, ctx, testAuthRequest(signedIn.clientID), signedIn.username)
+		if request.OTPPending() {
+			request = enterTOTPCode(t, s, ctx, request)
+		}
+		if err := s.SaveAuthCode(ctx, request.GetID(), "code-"+request.GetID()); err != nil {
+			t.Fatal(err)
+		}
+		tokenID, refreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, request, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, issuedEntries{
+			subject:        request.GetSubject(),
+			clientID:       signedIn.clientID,
+			authRequestID:  request.GetID(),
+			tokenID:        tokenID,
+			refreshTokenID: refreshToken,
+		})
+	}
+	pending, err := s.CreateAuthRequest(ContextWithClientID(context.Background(), clientID), testAuthRequest(clientID), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, entries, pending.GetID()
+}
+
+func TestRevokeEntries(t *testing.T) {
+	alice, bob := testUsers["alice"].ID_, testUsers["bob"].ID_
+	tests := []struct {
+		name   string
+		filter EntryFilter
+		// pendingRevoked is set if the pending request, which has no user yet, matches
+		pendingRevoked bool
+	}{
+		{name: "user", filter: EntryFilter{Subject: alice}},
+		{name: "other user", filter: EntryFilter{Subject: bob}},
+		{name: "client", filter: EntryFilter{ClientID: "revoke-client"}, pendingRevoked: true},
+		{name: "user and client", filter: EntryFilter{Subject: alice, ClientID: "revoke-other-client"}},
+		{name: "unknown user", filter: EntryFilter{Subject: "unknown-id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, entries, pendingID := newRevocationStorage(t)
+
+			want := RevokedEntries{}
+			if tt.pendingRevoked {
+				want.AuthRequests++
+			}
+			for _, e := range entries {
+				if tt.filter.matches(e.subject, e.clientID) {
+					want.Tokens++
+					want.RefreshTokens++
+					want.AuthRequests++
+				}
+			}
+			revoked, err := s.RevokeEntries(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked != want {
+				t.Errorf("RevokeEntries() = %+v, want %+v", revoked, want)
+			}
+
+			for _, e := range entries {
+				matches := tt.filter.matches(e.subject, e.clientID)
+				_, tokenErr := s.tokens.get(e.tokenID)
+				_, refreshTokenErr := s.refreshTokens.get(e.refreshTokenID)
+				_, authRequestErr := s.authRequests.get(e.authRequestID)
+				_, codeErr := s.codes.get("code-" + e.authRequestID)
+				for entry, err := range map[string]error{
+					"access token":  tokenErr,
+					"refresh token": refreshTokenErr,
+					"auth request":  authRequestErr,
+					"code":          codeErr,
+				} {
+					if removed := errors.Is(err, ErrNotFound); removed != matches {
+						t.Errorf("%s of %s for %s: removed = %v, want %v", entry, e.subject, e.clientID, removed, matches)
+					}
+				}
+			}
+			if _, err := s.authRequests.get(pendingID); errors.Is(err, ErrNotFound) != tt.pendingRevoked {
+				t.Errorf("pending auth request: removed = %v, want %v", errors.Is(err, ErrNotFound), tt.pendingRevoked)
+			}
+		})
+	}
+}
+
+func TestRevokeByID(t *testing.T) {
+	s, entries, pendingID := newRevocationStorage(t)
+	ctx := context.Background()
+
+	if err := s.RevokeRefreshToken(ctx, entries[0].refreshTokenID); err != nil {
+		t.Fatalf("RevokeRefreshToken() error = %v", err)
+	}
+	if _, err := s.tokens.get(entries[0].tokenID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("access token of the revoked refresh token: error = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeAuthRequest(ctx, entries[0].authRequestID); err != nil {
+		t.Fatalf("RevokeAuthRequest() error = %v", err)
+	}
+	if _, err := s.codes.get("code-" + entries[0].authRequestID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("code of the revoked auth request: error = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeAccessToken(ctx, entries[2].tokenID); err != nil {
+		t.Fatalf("RevokeAccessToken() error = %v", err)
+	}
+	if _, err := s.refreshTokens.get(entries[2].refreshTokenID); err != nil {
+		t.Errorf("refresh token of the revoked access token: %v", err)
+	}
+
+	for entry, revoke := range map[string]func(context.Context, string) error{
+		"access token":  s.RevokeAccessToken,
+		"refresh token": s.RevokeRefreshToken,
+		"auth request":  s.RevokeAuthRequest,
+	} {
+		if err := revoke(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("revoking unknown %s: error = %v, want ErrNotFound", entry, err)
+		}
+	}
+
+	// nothing else was removed
+	tokens, err := s.Tokens(ctx, EntryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshTokens, err := s.RefreshTokens(ctx, EntryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authRequests, err := s.AuthRequests(ctx, EntryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != entries[1].tokenID {
+		t.Errorf("access tokens = %+v, want the one of %s for %s", tokens, entries[1].subject, entries[1].clientID)
+	}
+	if len(refreshTokens) != 2 {
+		t.Errorf("refresh tokens = %d, want 2", len(refreshTokens))
+	}
+	if len(authRequests) != 3 {
+		t.Errorf("auth requests = %d, want 3", len(authRequests))
+	}
+	if _, err := s.authRequests.get(pendingID); err != nil {
+		t.Errorf("pending auth request: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+)
+
+const (
+	// backChannelLogoutEvent is the member of the events claim identifying logout tokens.
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// logoutTokenType is the typ header of logout tokens.
+	logoutTokenType = "logout+jwt"
+	// logoutTokenLifetime is kept short, since logout tokens are delivered right away.
+	logoutTokenLifetime = 2 * time.Minute
+)
+
+// backChannelLogoutClient delivers logout tokens. Unresponsive clients must not keep
+// deliveries pending forever.
+var backChannelLogoutClient = &http.Client{Timeout: 5 * time.Second}
+
+// logoutTokenClaims are the claims of a logout token as defined in
+// OpenID Connect Back-Channel Logout 1.0 section 2.4.
+type logoutTokenClaims struct {
+	Issuer    string                 `json:"iss"`
+	Subject   string                 `json:"sub,omitempty"`
+	Audience  oidc.Audience          `json:"aud"`
+	IssuedAt  oidc.Time              `json:"iat"`
+	Expiry    oidc.Time              `json:"exp"`
+	JWTID     string                 `json:"jti"`
+	Events    map[string]interface{} `json:"events"`
+	SessionID string                 `json:"sid,omitempty"`
+}
+
+// sessionClients returns the IDs of the clients the user holds tokens for.
+// s.lock must be held.
+func (s *Storage[T]) sessionClients(userID string) ([]string, error) {
+	seen := map[string]bool{}
+	var clientIDs []string
+	add := func(clientID string) {
+		if !seen[clientID] {
+			seen[clientID] = true
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+
+	tokens, err := s.tokens.all()
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		if token.Subject == userID {
+			add(token.ApplicationID)
+		}
+	}
+	refreshTokens, err := s.refreshTokens.all()
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range refreshTokens {
+		if token.UserID == userID && !token.Rotated {
+			add(token.ApplicationID)
+		}
+	}
+	return clientIDs, nil
+}
+
+// backChannelLogout posts a logout token for the user to every client with a back-channel
+// logout URI. Deliveries happen in the background and their results are logged.
+func (s *Storage[T]) backChannelLogout(issuer, userID, sessionID string, clientIDs []string) {
+	for _, clientID := range clientIDs {
+		client, ok := getClient(clientID)
+		if !ok || client.backChannelLogoutURI == "" {
+			continue
+		}
+
+		now := time.Now()
+		token, err := s.keys.sign(&logoutTokenClaims{
+			Issuer:    issuer,
+			Subject:   userID,
+			Audience:  oidc.Audience{client.id},
+			IssuedAt:  oidc.FromTime(now),
+			Expiry:    oidc.FromTime(now.Add(logoutTokenLifetime)),
+			JWTID:     uuid.NewString(),
+			Events:    map[string]interface{}{backChannelLogoutEvent: struct{}{}},
+			SessionID: sessionID,
+		}, client.idTokenSignedResponseAlg, logoutTokenType)
+		if err != nil {
+			log.Printf("back-channel logout for client %s: signing logout token: %v", client.id, err)
+			continue
+		}
+
+		go deliverLogoutToken(client.id, client.backChannelLogoutURI, token)
+	}
+}
+
+func deliverLogoutToken(clientID, uri, token string) {
+	resp, err := backChannelLogoutClient.PostForm(uri, url.Values{"logout_token": {token}})
+	if err != nil {
+		log.Printf("back-channel logout for client %s failed: %v", clientID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Printf("back-channel logout for client %s failed: %s responded %s", clientID, uri, resp.Status)
+		return
+	}
+	log.Printf("back-channel logout for client %s delivered", clientID)
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestBackChannelLogout(t *testing.T) {
+	const issuer = "http://localhost:10001"
+	tests := []struct {
+		name string
+		alg  jose.SignatureAlgorithm
+		// withSession is set if the browser signing out has a session, whose ID is then sent as sid
+		withSession bool
+	}{
+		{name: "RS256 with session", alg: jose.RS256, withSession: true},
+		{name: "ES256 with session", alg: jose.ES256, withSession: true},
+		{name: "without session", alg: jose.RS256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logoutTokens := make(chan string, 1)
+			rp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("logout token delivered with %s, want POST", r.Method)
+				}
+				logoutTokens <- r.PostFormValue("logout_token")
+			}))
+			defer rp.Close()
+
+			s := newTestStorage(t)
+			clientID := "backchannel-" + string(tt.alg)
+			client, err := NewClient(ClientConfig{
+				ID:                       clientID,
+				Secret:                   "secret",
+				RedirectURIs:             []string{testRedirectURI},
+				IDTokenSignedResponseAlg: tt.alg,
+			}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			registerTestClient(t, client.WithBackChannelLogoutURI(rp.URL))
+			ctx := op.ContextWithIssuer(ContextWithClientID(context.Background(), clientID), issuer)
+
+			request := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+			var sessionID string
+			if tt.withSession {
+				session, err := s.CreateSession(ctx, request.GetID())
+				if err != nil {
+					t.Fatal(err)
+				}
+				sessionID = session.ID
+				ctx = ContextWithSessionIDs(ctx, []string{sessionID})
+			}
+			if _, _, err := s.CreateAccessToken(ctx, request); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.TerminateSession(ctx, request.UserID, clientID); err != nil {
+				t.Fatal(err)
+			}
+
+			var logoutToken string
+			select {
+			case logoutToken = <-logoutTokens:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no logout token delivered")
+			}
+
+			jws, err := jose.ParseSigned(logoutToken)
+			if err != nil {
+				t.Fatalf("parsing logout token: %v", err)
+			}
+			header := jws.Signatures[0].Header
+			if header.Algorithm != string(tt.alg) {
+				t.Errorf("alg = %s, want %s", header.Algorithm, tt.alg)
+			}
+			if typ := header.ExtraHeaders[jose.HeaderType]; typ != logoutTokenType {
+				t.Errorf("typ = %v, want %s", typ, logoutTokenType)
+			}
+			key := publicKeyByID(s.keys.publicKeys(), header.KeyID)
+			if key == nil {
+				t.Fatalf("logout token signed with unpublished key %s", header.KeyID)
+			}
+			payload, err := jws.Verify(key.Key())
+			if err != nil {
+				t.Fatalf("verifying logout token: %v", err)
+			}
+
+			var claims map[string]interface{}
+			if err := json.Unmarshal(payload, &claims); err != nil {
+				t.Fatal(err)
+			}
+			if claims["iss"] != issuer {
+				t.Errorf("iss = %v, want %s", claims["iss"], issuer)
+			}
+			if aud, _ := claims["aud"].([]interface{}); len(aud) != 1 || aud[0] != clientID {
+				t.Errorf("aud = %v, want [%s]", claims["aud"], clientID)
+			}
+			if claims["sub"] != request.UserID {
+				t.Errorf("sub = %v, want %s", claims["sub"], request.UserID)
+			}
+			if sid, _ := claims["sid"].(string); sid != sessionID {
+				t.Errorf("sid = %q, want %q", sid, sessionID)
+			}
+			events, _ := claims["events"].(map[string]interface{})
+			if _, ok := events[backChannelLogoutEvent]; !ok {
+				t.Errorf("events = %v, want %s member", claims["events"], backChannelLogoutEvent)
+			}
+			if _, ok := claims["nonce"]; ok {
+				t.Error("logout token has a nonce")
+			}
+			for _, claim := range []string{"iat", "exp", "jti"} {
+				if _, ok := claims[claim]; !ok {
+					t.Errorf("logout token has no %s", claim)
+				}
+			}
+		})
+	}
+}
+
+func publicKeyByID(keys []op.Key, id string) op.Key {
+	for _, key := range keys {
+		if key.ID() == id {
+			return key
+		}
+	}
+	return nil
+}
+
+func TestTerminateSessionRevokesTokens(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "terminate-client"
+	otherClientID := "terminate-other-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	registerTestClient(t, WebClient(otherClientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+	otherCtx := ContextWithClientID(context.Background(), otherClientID)
+
+	_, withSession := startSession(t, s, ctx, clientID, "alice")
+	issue := func(ctx context.Context, clientID, username string) (string, string) {
+		t.Helper()
+		accessToken, refreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, signIn(t, s, ctx, testAuthRequest(clientID), username), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return accessToken, refreshToken
+	}
+	aliceToken, aliceRefreshToken := issue(ctx, clientID, "alice")
+	aliceOtherToken, aliceOtherRefreshToken := issue(otherCtx, otherClientID, "alice")
+	bob := enterTOTPCode(t, s, otherCtx, signIn(t, s, otherCtx, testAuthRequest(otherClientID), "bob"))
+	bobToken, bobRefreshToken, _, err := s.CreateAccessAndRefreshTokens(otherCtx, bob, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.TerminateSession(withSession, testUsers["alice"].ID_, clientID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tokenID := range []string{aliceToken, aliceOtherToken} {
+		if _, err := s.tokens.get(tokenID); err == nil {
+			t.Errorf("access token %s of the user signed out was kept", tokenID)
+		}
+	}
+	for _, token := range []string{aliceRefreshToken, aliceOtherRefreshToken} {
+		if _, err := s.refreshTokens.get(token); err == nil {
+			t.Errorf("refresh token %s of the user signed out was kept", token)
+		}
+	}
+	if _, err := s.tokens.get(bobToken); err != nil {
+		t.Errorf("access token of another user: %v", err)
+	}
+	if _, err := s.refreshTokens.get(bobRefreshToken); err != nil {
+		t.Errorf("refresh token of another user: %v", err)
+	}
+}
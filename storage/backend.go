@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// ErrNotFound is returned when an entry doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// buckets the state of Storage is kept in
+const (
+	bucketTokens        = "tokens"
+	bucketRefreshTokens = "refreshTokens"
+	bucketAuthRequests  = "authRequests"
+	bucketCodes         = "codes"
+	bucketDeviceCodes   = "deviceCodes"
+	bucketUserCodes     = "userCodes"
+	bucketSessions      = "sessions"
+	bucketGrants        = "grants"
+)
+
+var buckets = []string{
+	bucketTokens,
+	bucketRefreshTokens,
+	bucketAuthRequests,
+	bucketCodes,
+	bucketDeviceCodes,
+	bucketUserCodes,
+	bucketSessions,
+	bucketGrants,
+}
+
+// Backend stores the state of Storage, i.e. tokens, refresh tokens, auth requests, codes,
+// device authorizations, sessions and grants, as JSON encoded values in named buckets.
+// Implementations must be safe for concurrent use.
+type Backend interface {
+	// Get returns the value for key in bucket or ErrNotFound.
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	// Delete removes the key from bucket, if it exists.
+	Delete(bucket, key string) error
+	// ForEach calls fn for every key in bucket. fn must not modify the bucket.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+type memoryBackend struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryBackend creates a Backend that keeps state in memory only,
+// which is lost on restart. It is the default backend.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+func (b *memoryBackend) Get(bucket, key string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	value, ok := b.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (b *memoryBackend) Put(bucket, key string, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.buckets[bucket] == nil {
+		b.buckets[bucket] = make(map[string][]byte)
+	}
+	b.buckets[bucket][key] = value
+	return nil
+}
+
+func (b *memoryBackend) Delete(bucket, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.buckets[bucket], key)
+	return nil
+}
+
+func (b *memoryBackend) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for key, value := range b.buckets[bucket] {
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
+
+// table is a typed view of a Backend bucket.
+// Values are copies, so modified values must be put back.
+type table[V any] struct {
+	backend Backend
+	bucket  string
+}
+
+func (t table[V]) get(key string) (*V, error) {
+	data, err := t.backend.Get(t.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	v := new(V)
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (t table[V]) put(key string, v *V) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return t.backend.Put(t.bucket, key, data)
+}
+
+func (t table[V]) delete(key string) error {
+	return t.backend.Delete(t.bucket, key)
+}
+
+// all returns every value in the table by key.
+func (t table[V]) all() (map[string]*V, error) {
+	values := make(map[string]*V)
+	err := t.backend.ForEach(t.bucket, func(key string, data []byte) error {
+		v := new(V)
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		values[key] = v
+		return nil
+	})
+	return values, err
+}
+
+// deleteWhere removes all values matching fn and returns the number of removed values.
+func (t table[V]) deleteWhere(fn func(key string, v *V) bool) (int, error) {
+	values, err := t.all()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for key, v := range values {
+		if !fn(key, v) {
+			continue
+		}
+		if err := t.delete(key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
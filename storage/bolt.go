@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+// NewBoltBackend creates a Backend that persists state in the bbolt database at path,
+// so that sessions survive restarts. The file is created if it doesn't exist.
+func NewBoltBackend(path string) (Backend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltBackend{db: db}, nil
+}
+
+func (b *boltBackend) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(bucket)).Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		// values are only valid during the transaction
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	return value, err
+}
+
+func (b *boltBackend) Put(bucket, key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).Put([]byte(key), value)
+	})
+}
+
+func (b *boltBackend) Delete(bucket, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).Delete([]byte(key))
+	})
+}
+
+func (b *boltBackend) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			return fn(string(k), append([]byte(nil), v...))
+		})
+	})
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
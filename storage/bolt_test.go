@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltBackendRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	openStorage := func() *Storage[testUser] {
+		backend, err := NewBoltBackend(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newTestStorage(t, WithBackend(backend))
+	}
+	clientID := "bolt-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	s := openStorage()
+	request := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+	session, err := s.CreateSession(ctx, request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request, err = s.authRequests.get(request.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	tokenID, _, err := s.CreateAccessToken(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.tokens.get(tokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStorage()
+	defer s.Close()
+
+	restored, err := s.authRequests.get(request.GetID())
+	if err != nil {
+		t.Fatalf("auth request: %v", err)
+	}
+	if !restored.Done() || restored.UserID != request.UserID || restored.SessionID != session.ID {
+		t.Errorf("auth request: done = %v, user = %q, session = %q, want done for %q in %q",
+			restored.Done(), restored.UserID, restored.SessionID, request.UserID, session.ID)
+	}
+	if !equalStrings(restored.GetAMR(), request.GetAMR()) || restored.GetACR() != request.GetACR() || !restored.GetAuthTime().Equal(request.GetAuthTime()) {
+		t.Errorf("auth request: amr = %v, acr = %q, auth_time = %v, want %v, %q, %v",
+			restored.GetAMR(), restored.GetACR(), restored.GetAuthTime(), request.GetAMR(), request.GetACR(), request.GetAuthTime())
+	}
+	if restored.GetACR() == "" || restored.GetAuthTime().IsZero() {
+		t.Error("auth request: acr or auth_time missing")
+	}
+
+	restoredToken, err := s.tokens.get(tokenID)
+	if err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	if restoredToken.Subject != token.Subject || restoredToken.ApplicationID != token.ApplicationID ||
+		restoredToken.ACR != token.ACR || !equalStrings(restoredToken.Scopes, token.Scopes) || !restoredToken.Expiration.Equal(token.Expiration) {
+		t.Errorf("token = %+v, want %+v", restoredToken, token)
+	}
+
+	restoredSession, err := s.sessions.get(session.ID)
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	if restoredSession.UserID != session.UserID || restoredSession.ACR != session.ACR ||
+		!equalStrings(restoredSession.AMR, session.AMR) || !restoredSession.AuthTime.Equal(session.AuthTime) ||
+		restoredSession.Expiration.Before(time.Now()) {
+		t.Errorf("session = %+v, want %+v", restoredSession, session)
+	}
+	sessions, err := s.Sessions(ContextWithSessionIDs(ctx, []string{session.ID}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("browser sessions after restart = %d, want 1", len(sessions))
+	}
+}
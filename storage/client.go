@@ -1,11 +1,21 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zitadel/oidc/v2/pkg/oidc"
 	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
 )
 
 var (
@@ -20,8 +30,9 @@ var (
 		}
 	}
 
-	// clients to be used by the storage interface
-	clients = map[string]*Client{}
+	// clients to be used by the storage interface, guarded by clientsLock
+	clients     = map[string]*Client{}
+	clientsLock sync.RWMutex
 )
 
 // Client represents the storage model of an OAuth/OIDC client
@@ -30,6 +41,7 @@ type Client struct {
 	id                             string
 	secret                         string
 	redirectURIs                   []string
+	postLogoutRedirectURIs         []string
 	applicationType                op.ApplicationType
 	authMethod                     oidc.AuthMethod
 	loginURL                       func(string) string
@@ -41,6 +53,18 @@ type Client struct {
 	clockSkew                      time.Duration
 	postLogoutRedirectURIGlobs     []string
 	redirectURIGlobs               []string
+	idTokenSignedResponseAlg       jose.SignatureAlgorithm
+	registrationAccessToken        string
+	// tokenLifetimes overrides the server defaults where set
+	tokenLifetimes       TokenLifetimes
+	refreshTokenRotation RefreshTokenRotation
+	// backChannelLogoutURI receives logout tokens when users sign out, if set
+	backChannelLogoutURI string
+	// frontChannelLogoutURI is loaded in an iframe when users sign out, if set
+	frontChannelLogoutURI             string
+	frontChannelLogoutSessionRequired bool
+	// consentRequired shows users the consent page for scopes they didn't grant the client yet
+	consentRequired bool
 }
 
 // GetID must return the client_id
@@ -48,6 +72,17 @@ func (c *Client) GetID() string {
 	return c.id
 }
 
+// Secret returns the client_secret, if any.
+func (c *Client) Secret() string {
+	return c.secret
+}
+
+// RegistrationAccessToken returns the token to manage the client with (RFC 7592).
+// It is only set for dynamically registered clients.
+func (c *Client) RegistrationAccessToken() string {
+	return c.registrationAccessToken
+}
+
 // RedirectURIs must return the registered redirect_uris for Code and Implicit Flow
 func (c *Client) RedirectURIs() []string {
 	return c.redirectURIs
@@ -55,7 +90,7 @@ func (c *Client) RedirectURIs() []string {
 
 // PostLogoutRedirectURIs must return the registered post_logout_redirect_uris for sign-outs
 func (c *Client) PostLogoutRedirectURIs() []string {
-	return []string{}
+	return c.postLogoutRedirectURIs
 }
 
 // ApplicationType must return the type of the client (app, native, user agent)
@@ -90,9 +125,96 @@ func (c *Client) AccessTokenType() op.AccessTokenType {
 	return c.accessTokenType
 }
 
+// WithPostLogoutRedirectURIs sets the URIs users may be redirected to after signing out.
+func (c *Client) WithPostLogoutRedirectURIs(uris ...string) *Client {
+	c.postLogoutRedirectURIs = uris
+	return c
+}
+
+// WithBackChannelLogoutURI sets the URI logout tokens are posted to when users sign out.
+func (c *Client) WithBackChannelLogoutURI(uri string) *Client {
+	c.backChannelLogoutURI = uri
+	return c
+}
+
+// WithFrontChannelLogoutURI sets the URI loaded in an iframe when users sign out.
+// If sessionRequired, the iss and sid query parameters are added to it.
+func (c *Client) WithFrontChannelLogoutURI(uri string, sessionRequired bool) *Client {
+	c.frontChannelLogoutURI = uri
+	c.frontChannelLogoutSessionRequired = sessionRequired
+	return c
+}
+
+// WithConsentRequired makes users consent to the scopes the client requests,
+// unless they already granted them.
+func (c *Client) WithConsentRequired(consentRequired bool) *Client {
+	c.consentRequired = consentRequired
+	return c
+}
+
+// WithAccessTokenType sets the type of access tokens issued to this client.
+// JWT access tokens follow RFC 9068 and include the claims of GetPrivateClaimsFromScopesFunc.
+func (c *Client) WithAccessTokenType(accessTokenType op.AccessTokenType) *Client {
+	c.accessTokenType = accessTokenType
+	return c
+}
+
+// RefreshTokenRotation returns what happens to refresh tokens of this client when they are used.
+func (c *Client) RefreshTokenRotation() RefreshTokenRotation {
+	if c.refreshTokenRotation == "" {
+		return RefreshTokenRotate
+	}
+	return c.refreshTokenRotation
+}
+
+// WithRefreshTokenRotation sets what happens to refresh tokens of this client when they are used.
+func (c *Client) WithRefreshTokenRotation(rotation RefreshTokenRotation) *Client {
+	c.refreshTokenRotation = rotation
+	return c
+}
+
 // IDTokenLifetime must return the lifetime of the client's id_tokens
 func (c *Client) IDTokenLifetime() time.Duration {
-	return 1 * time.Hour
+	if c.tokenLifetimes.IDToken == 0 {
+		return defaultTokenLifetimes.IDToken
+	}
+	return c.tokenLifetimes.IDToken
+}
+
+// WithTokenLifetimes overrides the server default lifetimes of tokens issued to this client.
+// Zero values keep the server defaults.
+func (c *Client) WithTokenLifetimes(lifetimes TokenLifetimes) *Client {
+	c.tokenLifetimes = lifetimes
+	return c
+}
+
+// IDTokenSignedResponseAlg returns the algorithm tokens for this client are signed with.
+// The server default is used if empty.
+func (c *Client) IDTokenSignedResponseAlg() jose.SignatureAlgorithm {
+	return c.idTokenSignedResponseAlg
+}
+
+// BackChannelLogoutURI returns the URI logout tokens are posted to, if any.
+func (c *Client) BackChannelLogoutURI() string {
+	return c.backChannelLogoutURI
+}
+
+// FrontChannelLogoutURI returns the URI loaded in an iframe when users sign out, if any.
+func (c *Client) FrontChannelLogoutURI() string {
+	return c.frontChannelLogoutURI
+}
+
+// FrontChannelLogoutSessionRequired reports whether the iss and sid query parameters
+// are added to the front-channel logout URI.
+func (c *Client) FrontChannelLogoutSessionRequired() bool {
+	return c.frontChannelLogoutSessionRequired
+}
+
+// WithIDTokenSignedResponseAlg sets the algorithm id_tokens and JWT access tokens
+// for this client are signed with (see SupportedSigningAlgorithms).
+func (c *Client) WithIDTokenSignedResponseAlg(alg jose.SignatureAlgorithm) *Client {
+	c.idTokenSignedResponseAlg = alg
+	return c
 }
 
 // DevMode enables the use of non-compliant configs such as redirect_uris (e.g. http schema for user agent client)
@@ -138,9 +260,10 @@ func (c *Client) ClockSkew() time.Duration {
 // there are some clients (web and native) to try out different cases
 // add more if necessary
 //
-// RegisterClients should be called before the Storage is used so that there are
-// no race conditions.
+// RegisterClients may be called at any time, clients are replaced if already registered.
 func RegisterClients(registerClients ...*Client) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
 	for _, client := range registerClients {
 		clients[client.id] = client
 	}
@@ -242,3 +365,209 @@ func RedirectGlobsClient(client *Client) op.Client {
 	}
 	return client
 }
+
+// Duration is a time.Duration that is represented as a string in JSON, e.g. "10s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+// ClientConfig represents a client definition in ${DATA_DIR}/clients/*.json.
+// See Client for the meaning of each field.
+type ClientConfig struct {
+	ID                         string                  `json:"id"`
+	Secret                     string                  `json:"secret"`
+	ApplicationType            op.ApplicationType      `json:"applicationType"`
+	AuthMethod                 oidc.AuthMethod         `json:"authMethod"`
+	GrantTypes                 []oidc.GrantType        `json:"grantTypes"`
+	ResponseTypes              []oidc.ResponseType     `json:"responseTypes"`
+	AccessTokenType            op.AccessTokenType      `json:"accessTokenType"`
+	RedirectURIs               []string                `json:"redirectURIs"`
+	PostLogoutRedirectURIs     []string                `json:"postLogoutRedirectURIs"`
+	RedirectURIGlobs           []string                `json:"redirectURIGlobs"`
+	PostLogoutRedirectURIGlobs []string                `json:"postLogoutRedirectURIGlobs"`
+	DevMode                    bool                    `json:"devMode"`
+	ClockSkew                  Duration                `json:"clockSkew"`
+	IDTokenSignedResponseAlg   jose.SignatureAlgorithm `json:"idTokenSignedResponseAlg"`
+	// Token lifetimes override the server defaults if set, e.g. "10s".
+	AccessTokenLifetime      Duration `json:"accessTokenLifetime"`
+	RefreshTokenLifetime     Duration `json:"refreshTokenLifetime"`
+	RefreshTokenIdleLifetime Duration `json:"refreshTokenIdleLifetime"`
+	IDTokenLifetime          Duration `json:"idTokenLifetime"`
+	// RefreshTokenRotation is one of "rotate" (default), "reuse-allowed" or "reuse-detect".
+	RefreshTokenRotation RefreshTokenRotation `json:"refreshTokenRotation"`
+	// BackChannelLogoutURI receives logout tokens when users sign out.
+	BackChannelLogoutURI string `json:"backChannelLogoutURI"`
+	// FrontChannelLogoutURI is loaded in an iframe when users sign out, including
+	// the iss and sid query parameters if FrontChannelLogoutSessionRequired.
+	FrontChannelLogoutURI             string `json:"frontChannelLogoutURI"`
+	FrontChannelLogoutSessionRequired bool   `json:"frontChannelLogoutSessionRequired"`
+	// ConsentRequired shows users the consent page for scopes they didn't grant yet,
+	// and always with prompt=consent.
+	ConsentRequired bool `json:"consentRequired"`
+	// IDTokenUserinfoClaimsAssertion defaults to true if omitted.
+	IDTokenUserinfoClaimsAssertion *bool `json:"idTokenUserinfoClaimsAssertion"`
+}
+
+// NewClient validates the given configuration and creates a client from it.
+// Omitted grant and response types default to the authorization code flow.
+func NewClient(config ClientConfig, pathPrefix string) (*Client, error) {
+	if config.ID == "" {
+		return nil, errors.New("missing id")
+	}
+	if config.AuthMethod == "" {
+		config.AuthMethod = oidc.AuthMethodBasic
+	}
+	if !isAuthMethod(config.AuthMethod) {
+		return nil, fmt.Errorf("invalid authMethod: %s", config.AuthMethod)
+	}
+	if config.AuthMethod != oidc.AuthMethodNone && config.AuthMethod != oidc.AuthMethodPrivateKeyJWT && config.Secret == "" {
+		return nil, fmt.Errorf("missing secret for authMethod %s", config.AuthMethod)
+	}
+	if len(config.GrantTypes) == 0 {
+		config.GrantTypes = []oidc.GrantType{oidc.GrantTypeCode}
+	}
+	if len(config.ResponseTypes) == 0 {
+		config.ResponseTypes = []oidc.ResponseType{oidc.ResponseTypeCode}
+	}
+	if config.IDTokenSignedResponseAlg != "" && !isSupportedSigningAlgorithm(config.IDTokenSignedResponseAlg) {
+		return nil, fmt.Errorf("unsupported idTokenSignedResponseAlg: %s", config.IDTokenSignedResponseAlg)
+	}
+	if config.IDTokenSignedResponseAlg != "" && !hasClaimHash(config.IDTokenSignedResponseAlg) && issuesAccessTokensOrCodes(config.GrantTypes, config.ResponseTypes) {
+		return nil, fmt.Errorf("idTokenSignedResponseAlg %s requires the implicit grant type and id_token response type only, "+
+			"since at_hash and c_hash can't be computed for it", config.IDTokenSignedResponseAlg)
+	}
+	tokenLifetimes := TokenLifetimes{
+		AccessToken:      time.Duration(config.AccessTokenLifetime),
+		RefreshToken:     time.Duration(config.RefreshTokenLifetime),
+		RefreshTokenIdle: time.Duration(config.RefreshTokenIdleLifetime),
+		IDToken:          time.Duration(config.IDTokenLifetime),
+	}
+	if tokenLifetimes.AccessToken < 0 || tokenLifetimes.RefreshToken < 0 || tokenLifetimes.RefreshTokenIdle < 0 || tokenLifetimes.IDToken < 0 {
+		return nil, errors.New("token lifetimes must not be negative")
+	}
+	if config.RefreshTokenRotation == "" {
+		config.RefreshTokenRotation = RefreshTokenRotate
+	}
+	if !config.RefreshTokenRotation.valid() {
+		return nil, fmt.Errorf("invalid refreshTokenRotation: %s", config.RefreshTokenRotation)
+	}
+	idTokenUserinfoClaimsAssertion := true
+	if config.IDTokenUserinfoClaimsAssertion != nil {
+		idTokenUserinfoClaimsAssertion = *config.IDTokenUserinfoClaimsAssertion
+	}
+
+	return &Client{
+		id:                                config.ID,
+		secret:                            config.Secret,
+		redirectURIs:                      config.RedirectURIs,
+		postLogoutRedirectURIs:            config.PostLogoutRedirectURIs,
+		applicationType:                   config.ApplicationType,
+		authMethod:                        config.AuthMethod,
+		loginURL:                          defaultLoginURL(pathPrefix),
+		responseTypes:                     config.ResponseTypes,
+		grantTypes:                        config.GrantTypes,
+		accessTokenType:                   config.AccessTokenType,
+		devMode:                           config.DevMode,
+		idTokenUserinfoClaimsAssertion:    idTokenUserinfoClaimsAssertion,
+		clockSkew:                         time.Duration(config.ClockSkew),
+		postLogoutRedirectURIGlobs:        config.PostLogoutRedirectURIGlobs,
+		redirectURIGlobs:                  config.RedirectURIGlobs,
+		idTokenSignedResponseAlg:          config.IDTokenSignedResponseAlg,
+		tokenLifetimes:                    tokenLifetimes,
+		refreshTokenRotation:              config.RefreshTokenRotation,
+		backChannelLogoutURI:              config.BackChannelLogoutURI,
+		frontChannelLogoutURI:             config.FrontChannelLogoutURI,
+		frontChannelLogoutSessionRequired: config.FrontChannelLogoutSessionRequired,
+		consentRequired:                   config.ConsentRequired,
+	}, nil
+}
+
+// LoadClientsFromJSON reads client definitions from the JSON files in dataDir.
+// Each file contains key-value pairs of clients, where keys are ignored.
+func LoadClientsFromJSON(dataDir string, pathPrefix string) (map[string]*Client, error) {
+	files, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make(map[string]*Client)
+	errs := []string{}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		filePath := filepath.Join(dataDir, file.Name())
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		var cc map[string]ClientConfig
+		if err := json.Unmarshal(data, &cc); err != nil {
+			return nil, fmt.Errorf("invalid clients in %s: %w", filePath, err)
+		}
+
+		for key, config := range cc {
+			client, err := NewClient(config, pathPrefix)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s: %s", filePath, key, err))
+				continue
+			}
+			if _, exists := clients[client.id]; exists {
+				errs = append(errs, fmt.Sprintf("%s: %s: client with ID %s already exists", filePath, key, client.id))
+				continue
+			}
+			clients[client.id] = client
+		}
+
+		log.Printf("loaded clients from %s", filePath)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	return clients, nil
+}
+
+func isAuthMethod(authMethod oidc.AuthMethod) bool {
+	for _, m := range oidc.AllAuthMethods {
+		if m == authMethod {
+			return true
+		}
+	}
+	return false
+}
+
+// getClient returns the client with the given ID, if registered.
+func getClient(id string) (*Client, bool) {
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
+	client, ok := clients[id]
+	return client, ok
+}
+
+// randomSecret generates a URL-safe random secret.
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestNewClientSigningAlgorithm(t *testing.T) {
+	tests := []struct {
+		name          string
+		alg           jose.SignatureAlgorithm
+		grantTypes    []oidc.GrantType
+		responseTypes []oidc.ResponseType
+		wantErr       bool
+	}{
+		{name: "default", alg: ""},
+		{name: "ES256 code flow", alg: jose.ES256},
+		{name: "unsupported", alg: jose.HS256, wantErr: true},
+		{name: "EdDSA code flow", alg: jose.EdDSA, wantErr: true},
+		{
+			name:          "EdDSA implicit with access token",
+			alg:           jose.EdDSA,
+			grantTypes:    []oidc.GrantType{oidc.GrantTypeImplicit},
+			responseTypes: []oidc.ResponseType{oidc.ResponseTypeIDToken},
+			wantErr:       true,
+		},
+		{
+			name:          "EdDSA implicit id_token only",
+			alg:           jose.EdDSA,
+			grantTypes:    []oidc.GrantType{oidc.GrantTypeImplicit},
+			responseTypes: []oidc.ResponseType{oidc.ResponseTypeIDTokenOnly},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(ClientConfig{
+				ID:                       "client",
+				Secret:                   "secret",
+				GrantTypes:               tt.grantTypes,
+				ResponseTypes:            tt.responseTypes,
+				IDTokenSignedResponseAlg: tt.alg,
+			}, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateClient(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	config := ClientConfig{RedirectURIs: []string{testRedirectURI}}
+	client, err := s.RegisterClient(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		clientsLock.Lock()
+		defer clientsLock.Unlock()
+		delete(clients, client.id)
+	})
+
+	config.RedirectURIs = []string{"http://localhost:9999/other/callback"}
+	if _, err := s.UpdateClient(ctx, client.GetID(), "wrong", config); err == nil {
+		t.Error("client was updated with a wrong registration access token")
+	}
+	updated, err := s.UpdateClient(ctx, client.GetID(), client.RegistrationAccessToken(), config)
+	if err != nil {
+		t.Fatalf("UpdateClient() error = %v", err)
+	}
+	if got := updated.RedirectURIs(); len(got) != 1 || got[0] != config.RedirectURIs[0] {
+		t.Errorf("redirect URIs = %v, want %v", got, config.RedirectURIs)
+	}
+	if updated.Secret() != client.Secret() || updated.RegistrationAccessToken() != client.RegistrationAccessToken() {
+		t.Error("secret or registration access token changed on update")
+	}
+
+	if err := s.DeleteClient(ctx, client.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateClient(ctx, client.GetID(), client.RegistrationAccessToken(), config); err == nil {
+		t.Error("deleted client was updated")
+	}
+	if _, ok := getClient(client.GetID()); ok {
+		t.Error("deleted client was registered again by the update")
+	}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+)
+
+// Grant is the consent of a user to scopes requested by a client, which is remembered
+// so that the user isn't asked again for the same scopes.
+type Grant struct {
+	UserID       string    `json:"userID"`
+	ClientID     string    `json:"clientID"`
+	Scopes       []string  `json:"scopes"`
+	CreationDate time.Time `json:"creationDate"`
+}
+
+func grantKey(userID, clientID string) string {
+	return userID + ":" + clientID
+}
+
+// includes reports whether all scopes were granted.
+func (g *Grant) includes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !containsString(g.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// errConsentRequired is returned for prompt=none if the user has to consent
+// (OpenID Connect Core 1.0 section 3.1.2.6), which the library has no error for.
+func errConsentRequired() *oidc.Error {
+	return &oidc.Error{
+		ErrorType:   "consent_required",
+		Description: "The user has to consent to the requested scopes.",
+	}
+}
+
+// completeAuthRequest marks the request as authenticated for the user, pending consent if the client
+// requires it and the user didn't grant all requested scopes yet, or prompt=consent was requested.
+// s.lock must be held.
+func (s *Storage[T]) completeAuthRequest(request *AuthRequest, userID string, authTime time.Time, amr []string, acr string) error {
+	request.complete(userID, authTime, amr, acr)
+	request.consentPending = false
+
+	client, ok := getClient(request.ApplicationID)
+	if !ok || !client.consentRequired {
+		return nil
+	}
+	if request.HasPrompt(oidc.PromptConsent) {
+		request.consentPending = true
+		return nil
+	}
+	grant, err := s.grants.get(grantKey(userID, request.ApplicationID))
+	if errors.Is(err, ErrNotFound) {
+		request.consentPending = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	request.consentPending = !grant.includes(request.Scopes)
+	return nil
+}
+
+// GrantConsent remembers the consent of the user to the scopes of the auth request
+// in addition to previously granted ones, and completes the request.
+func (s *Storage[T]) GrantConsent(ctx context.Context, authRequestID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, err := s.authRequests.get(authRequestID)
+	if err != nil {
+		return errors.New("request not found")
+	}
+	if !request.ConsentPending() {
+		return errors.New("request is not pending consent")
+	}
+
+	key := grantKey(request.UserID, request.ApplicationID)
+	grant, err := s.grants.get(key)
+	if errors.Is(err, ErrNotFound) {
+		grant = &Grant{
+			UserID:   request.UserID,
+			ClientID: request.ApplicationID,
+		}
+	} else if err != nil {
+		return err
+	}
+	for _, scope := range request.Scopes {
+		if !containsString(grant.Scopes, scope) {
+			grant.Scopes = append(grant.Scopes, scope)
+		}
+	}
+	grant.CreationDate = time.Now()
+	if err := s.grants.put(key, grant); err != nil {
+		return err
+	}
+
+	request.consentPending = false
+	return s.authRequests.put(authRequestID, request)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+)
+
+func TestRememberedConsent(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "consent-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI).WithConsentRequired(true))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	request := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+	if !request.ConsentPending() || request.Done() {
+		t.Fatalf("first login: ConsentPending() = %v, Done() = %v, want pending", request.ConsentPending(), request.Done())
+	}
+	if err := s.GrantConsent(ctx, request.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	session, err := s.CreateSession(ctx, request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSession := ContextWithSessionIDs(ctx, []string{session.ID})
+
+	withProfile := testAuthRequest(clientID)
+	withProfile.Scopes = append(withProfile.Scopes, oidc.ScopeProfile)
+	promptConsent := testAuthRequest(clientID)
+	promptConsent.Prompt = oidc.SpaceDelimitedArray{oidc.PromptConsent}
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		req         *oidc.AuthRequest
+		username    string
+		wantConsent bool
+	}{
+		{name: "granted scopes from session", ctx: withSession, req: testAuthRequest(clientID)},
+		{name: "granted scopes after login", ctx: ctx, req: testAuthRequest(clientID), username: "alice"},
+		{name: "new scope", ctx: withSession, req: withProfile, wantConsent: true},
+		{name: "prompt=consent", ctx: withSession, req: promptConsent, wantConsent: true},
+		{name: "other user", ctx: ctx, req: testAuthRequest(clientID), username: "bob", wantConsent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request *AuthRequest
+			if tt.username == "" {
+				authReq, err := s.CreateAuthRequest(tt.ctx, tt.req, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				request = authReq.(*AuthRequest)
+			} else {
+				request = signIn(t, s, tt.ctx, tt.req, tt.username)
+				if request.OTPPending() {
+					request = enterTOTPCode(t, s, tt.ctx, request)
+				}
+			}
+			if request.ConsentPending() != tt.wantConsent || request.Done() == tt.wantConsent {
+				t.Errorf("ConsentPending() = %v, Done() = %v, want consent pending %v", request.ConsentPending(), request.Done(), tt.wantConsent)
+			}
+		})
+	}
+}
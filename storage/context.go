@@ -0,0 +1,46 @@
+package storage
+
+import "context"
+
+type contextKey int
+
+const (
+	clientIDContextKey contextKey = iota
+	sessionIDContextKey
+	codeVerifierContextKey
+	issuedAccessTokenContextKey
+)
+
+// ContextWithClientID returns a copy of ctx carrying the client the current request is made for,
+// which is used to pick client specific settings where the op.Storage interface provides no client,
+// e.g. the signing key.
+func ContextWithClientID(ctx context.Context, clientID string) context.Context {
+	return context.WithValue(ctx, clientIDContextKey, clientID)
+}
+
+func clientIDFromContext(ctx context.Context) string {
+	clientID, _ := ctx.Value(clientIDContextKey).(string)
+	return clientID
+}
+
+// ContextWithCodeVerifier returns a copy of ctx carrying the code_verifier of the current token request,
+// so that codes are only consumed by exchanges passing PKCE (see AuthRequestByCode).
+func ContextWithCodeVerifier(ctx context.Context, codeVerifier string) context.Context {
+	return context.WithValue(ctx, codeVerifierContextKey, codeVerifier)
+}
+
+func codeVerifierFromContext(ctx context.Context) string {
+	codeVerifier, _ := ctx.Value(codeVerifierContextKey).(string)
+	return codeVerifier
+}
+
+// ContextWithSessionIDs returns a copy of ctx carrying the IDs of the sessions of the browser
+// the current request is made with, e.g. from a cookie. The first unexpired one is active.
+func ContextWithSessionIDs(ctx context.Context, sessionIDs []string) context.Context {
+	return context.WithValue(ctx, sessionIDContextKey, sessionIDs)
+}
+
+func sessionIDsFromContext(ctx context.Context) []string {
+	sessionIDs, _ := ctx.Value(sessionIDContextKey).([]string)
+	return sessionIDs
+}
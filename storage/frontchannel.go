@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/zitadel/oidc/v2/pkg/op"
+)
+
+// FrontChannelLogoutURIs returns the front-channel logout URIs of the clients the user has
+// a session with, as per OpenID Connect Front-Channel Logout 1.0. It must be called before
+// the session is terminated.
+func (s *Storage[T]) FrontChannelLogoutURIs(ctx context.Context, userID string) ([]string, error) {
+	var sessionID string
+	clientIDs, err := func() ([]string, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		session, err := s.userSession(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			sessionID = session.ID
+		}
+		return s.sessionClients(userID)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	var uris []string
+	for _, clientID := range clientIDs {
+		client, ok := getClient(clientID)
+		if !ok || client.frontChannelLogoutURI == "" {
+			continue
+		}
+		uri := client.frontChannelLogoutURI
+		if client.frontChannelLogoutSessionRequired {
+			u, err := url.Parse(uri)
+			if err != nil {
+				return nil, err
+			}
+			query := u.Query()
+			query.Set("iss", op.IssuerFromContext(ctx))
+			if sessionID != "" {
+				query.Set("sid", sessionID)
+			}
+			u.RawQuery = query.Encode()
+			uri = u.String()
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartJanitor periodically purges expired entries on the schedule set via WithJanitor
+// until ctx is done.
+func (s *Storage[T]) StartJanitor(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.janitorInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.purgeExpired(now)
+			}
+		}
+	}()
+}
+
+// purgeExpired removes expired tokens, refresh tokens, device authorizations and sessions,
+// as well as expired codes, used codes past their replay window and abandoned auth requests.
+func (s *Storage[T]) purgeExpired(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tokens, err := s.tokens.deleteWhere(func(_ string, token *Token) bool {
+		return token.expired(now)
+	})
+	if err != nil {
+		log.Printf("could not purge expired tokens: %v", err)
+	}
+	refreshTokens, err := s.refreshTokens.deleteWhere(func(_ string, refreshToken *RefreshToken) bool {
+		return refreshToken.expired(now)
+	})
+	if err != nil {
+		log.Printf("could not purge expired refresh tokens: %v", err)
+	}
+	authRequests, err := s.authRequests.deleteWhere(func(_ string, authReq *AuthRequest) bool {
+		return now.After(authReq.CreationDate.Add(s.authRequestLifetime))
+	})
+	if err != nil {
+		log.Printf("could not purge expired auth requests: %v", err)
+	}
+	codes, err := s.codes.deleteWhere(func(_ string, authCode *authCode) bool {
+		if authCode.Used {
+			// replays of used codes are detected until the tokens issued from them have expired
+			return authCode.expired(now, s.codeReplayWindow(authCode.ClientID))
+		}
+		return authCode.expired(now, s.codeLifetime)
+	})
+	if err != nil {
+		log.Printf("could not purge expired codes: %v", err)
+	}
+	deviceCodes, err := s.deviceCodes.deleteWhere(func(_ string, entry *deviceAuthorizationEntry) bool {
+		if !now.After(entry.State.Expires) {
+			return false
+		}
+		if err := s.userCodes.delete(entry.UserCode); err != nil {
+			log.Printf("could not purge expired user code: %v", err)
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("could not purge expired device codes: %v", err)
+	}
+
+	sessions, err := s.sessions.deleteWhere(func(_ string, session *Session) bool {
+		return session.expired(now)
+	})
+	if err != nil {
+		log.Printf("could not purge expired sessions: %v", err)
+	}
+
+	if tokens+refreshTokens+authRequests+codes+deviceCodes+sessions > 0 {
+		log.Printf("purged expired entries: %d tokens, %d refresh tokens, %d auth requests, %d codes, %d device codes, %d sessions",
+			tokens, refreshTokens, authRequests, codes, deviceCodes, sessions)
+	}
+}
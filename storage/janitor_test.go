@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// updateEntry changes the stored entry with the given key.
+func updateEntry[V any](t *testing.T, tbl table[V], key string, change func(v *V)) {
+	t.Helper()
+	v, err := tbl.get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(v)
+	if err := tbl.put(key, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	s, entries, pendingID := newRevocationStorage(t)
+	now := time.Now()
+	sessionIDs := make([]string, len(entries))
+	for i, e := range entries {
+		session, err := s.CreateSession(ContextWithClientID(context.Background(), e.clientID), e.authRequestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessionIDs[i] = session.ID
+	}
+
+	// everything of the first sign-in expired
+	expired := entries[0]
+	updateEntry(t, s.tokens, expired.tokenID, func(token *Token) { token.Expiration = now.Add(-time.Second) })
+	updateEntry(t, s.refreshTokens, expired.refreshTokenID, func(refreshToken *RefreshToken) {
+		refreshToken.Expiration = now.Add(-time.Second)
+	})
+	updateEntry(t, s.sessions, sessionIDs[0], func(session *Session) { session.Expiration = now.Add(-time.Second) })
+	updateEntry(t, s.authRequests, expired.authRequestID, func(authReq *AuthRequest) {
+		authReq.CreationDate = now.Add(-s.authRequestLifetime - time.Second)
+	})
+	updateEntry(t, s.codes, "code-"+expired.authRequestID, func(authCode *authCode) {
+		authCode.IssuedAt = now.Add(-s.codeLifetime - time.Second)
+	})
+	// past their lifetime, used codes are kept for replay detection, unused ones aren't
+	updateEntry(t, s.codes, "code-"+entries[1].authRequestID, func(authCode *authCode) {
+		authCode.IssuedAt = now.Add(-s.codeLifetime - time.Second)
+		authCode.Used = true
+	})
+	updateEntry(t, s.codes, "code-"+entries[2].authRequestID, func(authCode *authCode) {
+		authCode.IssuedAt = now.Add(-s.codeLifetime - time.Second)
+	})
+
+	s.purgeExpired(now)
+
+	for _, tt := range []struct {
+		name    string
+		get     func() error
+		removed bool
+	}{
+		{name: "expired access token", get: getErr(s.tokens, expired.tokenID), removed: true},
+		{name: "expired refresh token", get: getErr(s.refreshTokens, expired.refreshTokenID), removed: true},
+		{name: "expired session", get: getErr(s.sessions, sessionIDs[0]), removed: true},
+		{name: "abandoned auth request", get: getErr(s.authRequests, expired.authRequestID), removed: true},
+		{name: "expired code", get: getErr(s.codes, "code-"+expired.authRequestID), removed: true},
+		{name: "used code within replay window", get: getErr(s.codes, "code-"+entries[1].authRequestID)},
+		{name: "unused expired code", get: getErr(s.codes, "code-"+entries[2].authRequestID), removed: true},
+		{name: "access token", get: getErr(s.tokens, entries[1].tokenID)},
+		{name: "refresh token", get: getErr(s.refreshTokens, entries[1].refreshTokenID)},
+		{name: "session", get: getErr(s.sessions, sessionIDs[1])},
+		{name: "auth request", get: getErr(s.authRequests, entries[1].authRequestID)},
+		{name: "pending auth request", get: getErr(s.authRequests, pendingID)},
+		{name: "access token of another user", get: getErr(s.tokens, entries[2].tokenID)},
+		{name: "session of another user", get: getErr(s.sessions, sessionIDs[2])},
+	} {
+		err := tt.get()
+		if removed := errors.Is(err, ErrNotFound); removed != tt.removed {
+			t.Errorf("%s: removed = %v, want %v (error = %v)", tt.name, removed, tt.removed, err)
+		}
+	}
+}
+
+// getErr returns a function looking up the entry with the given key, for its error.
+func getErr[V any](tbl table[V], key string) func() error {
+	return func() error {
+		_, err := tbl.get(key)
+		return err
+	}
+}
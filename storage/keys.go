@@ -0,0 +1,640 @@
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// retiredKeysDir is the subdirectory of the keys directory where public keys
+// of rotated signing keys are kept until their grace period ends.
+const retiredKeysDir = "retired"
+
+// SupportedSigningAlgorithms lists the algorithms signing keys can be generated for.
+// EdDSA is restricted to clients which are issued id_tokens only (see hasClaimHash).
+var SupportedSigningAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256,
+	jose.PS256,
+	jose.ES256,
+	jose.EdDSA,
+}
+
+func isSupportedSigningAlgorithm(alg jose.SignatureAlgorithm) bool {
+	for _, supported := range SupportedSigningAlgorithms {
+		if supported == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClaimHash reports whether the oidc library can compute the at_hash and c_hash claims
+// of id_tokens signed with alg, which are included whenever an access token or code is issued
+// alongside. It has no hash algorithm defined for EdDSA, failing to issue such id_tokens.
+func hasClaimHash(alg jose.SignatureAlgorithm) bool {
+	return alg != jose.EdDSA
+}
+
+// issuesAccessTokensOrCodes reports whether a client with the given grant and response types
+// may be issued id_tokens alongside an access token or code, i.e. unless it only uses
+// the implicit flow with response type id_token.
+func issuesAccessTokensOrCodes(grantTypes []oidc.GrantType, responseTypes []oidc.ResponseType) bool {
+	for _, grantType := range grantTypes {
+		if grantType != oidc.GrantTypeImplicit {
+			return true
+		}
+	}
+	for _, responseType := range responseTypes {
+		if responseType != oidc.ResponseTypeIDTokenOnly {
+			return true
+		}
+	}
+	return false
+}
+
+type signingKey struct {
+	id        string
+	algorithm jose.SignatureAlgorithm
+	key       crypto.Signer
+}
+
+func (s *signingKey) SignatureAlgorithm() jose.SignatureAlgorithm {
+	return s.algorithm
+}
+
+func (s *signingKey) Key() interface{} {
+	return s.key
+}
+
+func (s *signingKey) ID() string {
+	return s.id
+}
+
+func (s *signingKey) public() *publicKey {
+	return &publicKey{
+		id:        s.id,
+		algorithm: s.algorithm,
+		key:       s.key.Public(),
+	}
+}
+
+type publicKey struct {
+	id        string
+	algorithm jose.SignatureAlgorithm
+	key       crypto.PublicKey
+}
+
+func (s *publicKey) ID() string {
+	return s.id
+}
+
+func (s *publicKey) Algorithm() jose.SignatureAlgorithm {
+	return s.algorithm
+}
+
+func (s *publicKey) Use() string {
+	return "sig"
+}
+
+func (s *publicKey) Key() interface{} {
+	return s.key
+}
+
+type activeKey struct {
+	key   *signingKey
+	since time.Time
+}
+
+type retiredKey struct {
+	key       *publicKey
+	retiredAt time.Time
+}
+
+// keyManager holds the active signing key for each algorithm in use and the public keys
+// of previously active ones.
+// Rotated keys remain published in the JWKS for the configured grace period,
+// so that tokens signed before a rotation can still be verified.
+type keyManager struct {
+	mu               sync.RWMutex
+	dir              string
+	defaultAlgorithm jose.SignatureAlgorithm
+	rotationInterval time.Duration
+	gracePeriod      time.Duration
+	active           map[jose.SignatureAlgorithm]*activeKey
+	retired          []retiredKey
+}
+
+// newKeyManager loads or creates signing keys for defaultAlg and any of the additional algorithms.
+func newKeyManager(dir string, defaultAlg jose.SignatureAlgorithm, algs []jose.SignatureAlgorithm, rotationInterval, gracePeriod time.Duration) (*keyManager, error) {
+	k := &keyManager{
+		dir:              dir,
+		defaultAlgorithm: defaultAlg,
+		rotationInterval: rotationInterval,
+		gracePeriod:      gracePeriod,
+		active:           make(map[jose.SignatureAlgorithm]*activeKey),
+	}
+
+	for _, alg := range append([]jose.SignatureAlgorithm{defaultAlg}, algs...) {
+		if _, err := k.signingKey(alg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := k.loadRetiredKeys(); err != nil {
+		return nil, fmt.Errorf("could not load retired keys: %w", err)
+	}
+
+	return k, nil
+}
+
+// signingKey returns the active signing key for alg, loading or creating it on first use.
+// The default algorithm is used if alg is empty.
+func (k *keyManager) signingKey(alg jose.SignatureAlgorithm) (*signingKey, error) {
+	if alg == "" {
+		alg = k.defaultAlgorithm
+	}
+
+	k.mu.RLock()
+	active, ok := k.active[alg]
+	k.mu.RUnlock()
+	if ok {
+		return active.key, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if active, ok := k.active[alg]; ok {
+		return active.key, nil
+	}
+	key, since, err := loadOrCreateSigningKey(k.dir, alg)
+	if err != nil {
+		return nil, err
+	}
+	k.active[alg] = &activeKey{key: key, since: since}
+
+	return key, nil
+}
+
+// algorithms returns the algorithms with an active signing key, starting with the default one.
+func (k *keyManager) algorithms() []jose.SignatureAlgorithm {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.activeAlgorithms()
+}
+
+func (k *keyManager) activeAlgorithms() []jose.SignatureAlgorithm {
+	algs := []jose.SignatureAlgorithm{k.defaultAlgorithm}
+	for alg := range k.active {
+		if alg != k.defaultAlgorithm {
+			algs = append(algs, alg)
+		}
+	}
+	others := algs[1:]
+	sort.Slice(others, func(i, j int) bool {
+		return others[i] < others[j]
+	})
+
+	return algs
+}
+
+// publicKeys returns the public keys of the active signing keys and of
+// rotated keys that are still within their grace period.
+func (k *keyManager) publicKeys() []op.Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := []op.Key{}
+	for _, alg := range k.activeAlgorithms() {
+		keys = append(keys, k.active[alg].key.public())
+	}
+	for _, r := range k.retired {
+		if time.Since(r.retiredAt) < k.gracePeriod {
+			keys = append(keys, r.key)
+		}
+	}
+
+	return keys
+}
+
+// rotateAll replaces the active signing key of every algorithm in use and returns the new public keys.
+func (k *keyManager) rotateAll() ([]op.Key, error) {
+	keys := []op.Key{}
+	for _, alg := range k.algorithms() {
+		key, err := k.rotate(alg)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.public())
+	}
+
+	return keys, nil
+}
+
+// rotate replaces the active signing key for alg with a newly generated one.
+// The previous key's public key is kept for the grace period.
+func (k *keyManager) rotate(alg jose.SignatureAlgorithm) (*signingKey, error) {
+	key, err := generateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	previous, ok := k.active[alg]
+	if k.dir != "" {
+		if ok {
+			if err := writeJWK(filepath.Join(k.dir, retiredKeysDir, previous.key.id+".json"), previous.key.public().jwk()); err != nil {
+				return nil, fmt.Errorf("could not persist retired key: %w", err)
+			}
+		}
+		if err := writeJWK(filepath.Join(k.dir, string(alg)+".json"), key.jwk()); err != nil {
+			return nil, fmt.Errorf("could not persist signing key: %w", err)
+		}
+		// a PEM key would otherwise take precedence over the new key on next start
+		if err := os.Remove(filepath.Join(k.dir, string(alg)+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if ok {
+		k.retired = append(k.retired, retiredKey{key: previous.key.public(), retiredAt: now})
+		log.Printf("rotated %s signing key %s -> %s", alg, previous.key.id, key.id)
+	}
+	k.active[alg] = &activeKey{key: key, since: now}
+	k.pruneRetiredKeys()
+
+	return key, nil
+}
+
+// nextRotation returns the algorithm whose signing key is due for rotation next.
+func (k *keyManager) nextRotation() (jose.SignatureAlgorithm, time.Time) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var next time.Time
+	var nextAlg jose.SignatureAlgorithm
+	for alg, active := range k.active {
+		due := active.since.Add(k.rotationInterval)
+		if nextAlg == "" || due.Before(next) {
+			next = due
+			nextAlg = alg
+		}
+	}
+
+	return nextAlg, next
+}
+
+// run rotates signing keys every rotationInterval until ctx is done.
+func (k *keyManager) run(ctx context.Context) {
+	if k.rotationInterval <= 0 {
+		return
+	}
+
+	for {
+		_, next := k.nextRotation()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		alg, next := k.nextRotation()
+		if time.Now().Before(next) {
+			// rotated on demand in the meantime
+			continue
+		}
+
+		if _, err := k.rotate(alg); err != nil {
+			log.Printf("error rotating %s signing key: %s", alg, err)
+			// avoid retrying in a busy loop
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+			}
+		}
+	}
+}
+
+// pruneRetiredKeys drops retired keys whose grace period is over.
+func (k *keyManager) pruneRetiredKeys() {
+	retired := k.retired[:0]
+	for _, r := range k.retired {
+		if time.Since(r.retiredAt) < k.gracePeriod {
+			retired = append(retired, r)
+			continue
+		}
+		if k.dir != "" {
+			if err := os.Remove(filepath.Join(k.dir, retiredKeysDir, r.key.id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("could not remove retired key %s: %s", r.key.id, err)
+			}
+		}
+	}
+	k.retired = retired
+}
+
+// loadRetiredKeys reads persisted public keys of rotated signing keys.
+// The file modification time is used as the retirement time.
+func (k *keyManager) loadRetiredKeys() error {
+	if k.dir == "" {
+		return nil
+	}
+
+	dir := filepath.Join(k.dir, retiredKeysDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		filePath := filepath.Join(dir, file.Name())
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		var jwk jose.JSONWebKey
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			return fmt.Errorf("invalid key in %s: %w", filePath, err)
+		}
+		if !jwk.IsPublic() {
+			return fmt.Errorf("invalid key in %s: not a public key", filePath)
+		}
+		id := jwk.KeyID
+		if id == "" {
+			id = strings.TrimSuffix(file.Name(), ".json")
+		}
+		k.retired = append(k.retired, retiredKey{
+			key: &publicKey{
+				id:        id,
+				algorithm: jose.SignatureAlgorithm(jwk.Algorithm),
+				key:       jwk.Key,
+			},
+			retiredAt: info.ModTime(),
+		})
+	}
+	k.pruneRetiredKeys()
+
+	return nil
+}
+
+// loadOrCreateSigningKey reads the private signing key for the given algorithm from dir.
+// Keys may be provided either as a JWK (<alg>.json) or PEM (<alg>.pem) file.
+// If none exists, a new key is generated and written to dir as a JWK (see WithKeysDir).
+// It also returns the time since the key is in use, based on the file modification time.
+// An empty dir disables persistence and returns an ephemeral key.
+func loadOrCreateSigningKey(dir string, alg jose.SignatureAlgorithm) (*signingKey, time.Time, error) {
+	if dir == "" {
+		key, err := generateSigningKey(alg)
+		return key, time.Now(), err
+	}
+
+	jwkPath := filepath.Join(dir, string(alg)+".json")
+	pemPath := filepath.Join(dir, string(alg)+".pem")
+
+	for _, keyFile := range []struct {
+		path  string
+		parse func([]byte, jose.SignatureAlgorithm) (*signingKey, error)
+	}{
+		{path: jwkPath, parse: parseJWKSigningKey},
+		{path: pemPath, parse: parsePEMSigningKey},
+	} {
+		info, err := os.Stat(keyFile.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		data, err := os.ReadFile(keyFile.path)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		key, err := keyFile.parse(data, alg)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid signing key in %s: %w", keyFile.path, err)
+		}
+		log.Printf("loaded %s signing key %s from %s", alg, key.id, keyFile.path)
+
+		return key, info.ModTime(), nil
+	}
+
+	key, err := generateSigningKey(alg)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := writeJWK(jwkPath, key.jwk()); err != nil {
+		return nil, time.Time{}, err
+	}
+	log.Printf("generated %s signing key %s at %s", alg, key.id, jwkPath)
+
+	return key, time.Now(), nil
+}
+
+func generateSigningKey(alg jose.SignatureAlgorithm) (*signingKey, error) {
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case jose.RS256, jose.PS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jose.ES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jose.EdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not generate %s signing key: %w", alg, err)
+	}
+
+	return newSigningKey(key, alg)
+}
+
+func newSigningKey(key crypto.Signer, alg jose.SignatureAlgorithm) (*signingKey, error) {
+	if err := checkKeyAlgorithm(key, alg); err != nil {
+		return nil, err
+	}
+
+	// the thumbprint is used as key id so that it stays the same for a given key
+	thumbprint, err := (&jose.JSONWebKey{Key: key.Public()}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("could not compute key thumbprint: %w", err)
+	}
+
+	return &signingKey{
+		id:        base64.RawURLEncoding.EncodeToString(thumbprint),
+		algorithm: alg,
+		key:       key,
+	}, nil
+}
+
+// checkKeyAlgorithm validates that key can be used to sign with alg.
+func checkKeyAlgorithm(key crypto.Signer, alg jose.SignatureAlgorithm) error {
+	var ok bool
+	switch alg {
+	case jose.RS256, jose.PS256:
+		_, ok = key.(*rsa.PrivateKey)
+	case jose.ES256:
+		var ecKey *ecdsa.PrivateKey
+		ecKey, ok = key.(*ecdsa.PrivateKey)
+		ok = ok && ecKey.Curve == elliptic.P256()
+	case jose.EdDSA:
+		_, ok = key.(ed25519.PrivateKey)
+	default:
+		return fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if !ok {
+		return fmt.Errorf("%T cannot be used for %s", key, alg)
+	}
+
+	return nil
+}
+
+func parseJWKSigningKey(data []byte, alg jose.SignatureAlgorithm) (*signingKey, error) {
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	if jwk.Algorithm != "" && jwk.Algorithm != string(alg) {
+		return nil, fmt.Errorf("expected algorithm %s, got %s", alg, jwk.Algorithm)
+	}
+	key, ok := jwk.Key.(crypto.Signer)
+	if !ok || jwk.IsPublic() {
+		return nil, fmt.Errorf("not a private key")
+	}
+
+	signingKey, err := newSigningKey(key, alg)
+	if err != nil {
+		return nil, err
+	}
+	if jwk.KeyID != "" {
+		signingKey.id = jwk.KeyID
+	}
+
+	return signingKey, nil
+}
+
+func parsePEMSigningKey(data []byte, alg jose.SignatureAlgorithm) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return newSigningKey(signer, alg)
+}
+
+func (s *signingKey) jwk() jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       s.key,
+		KeyID:     s.id,
+		Algorithm: string(s.algorithm),
+		Use:       "sig",
+	}
+}
+
+func (s *publicKey) jwk() jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       s.key,
+		KeyID:     s.id,
+		Algorithm: string(s.algorithm),
+		Use:       "sig",
+	}
+}
+
+func writeJWK(path string, jwk jose.JSONWebKey) error {
+	data, err := json.MarshalIndent(jwk, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// sign signs the claims as JWT with the active key for alg (the default if empty)
+// and the given typ header, for tokens the library doesn't create.
+func (k *keyManager) sign(claims interface{}, alg jose.SignatureAlgorithm, typ string) (string, error) {
+	key, err := k.signingKey(alg)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: key.SignatureAlgorithm(),
+		Key: &jose.JSONWebKey{
+			Key:   key.Key(),
+			KeyID: key.ID(),
+		},
+	}, (&jose.SignerOptions{}).WithType(jose.ContentType(typ)))
+	if err != nil {
+		return "", err
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return jws.CompactSerialize()
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestLoadOrCreateSigningKey(t *testing.T) {
+	for _, alg := range SupportedSigningAlgorithms {
+		t.Run(string(alg), func(t *testing.T) {
+			dir := t.TempDir()
+			created, _, err := loadOrCreateSigningKey(dir, alg)
+			if err != nil {
+				t.Fatalf("creating key: %v", err)
+			}
+			loaded, _, err := loadOrCreateSigningKey(dir, alg)
+			if err != nil {
+				t.Fatalf("loading key: %v", err)
+			}
+			if loaded.id != created.id || loaded.algorithm != alg {
+				t.Errorf("loaded key %s (%s), want %s (%s)", loaded.id, loaded.algorithm, created.id, alg)
+			}
+
+			ephemeral, _, err := loadOrCreateSigningKey("", alg)
+			if err != nil {
+				t.Fatalf("generating key: %v", err)
+			}
+			if ephemeral.id == created.id {
+				t.Error("key without dir equals persisted key")
+			}
+		})
+	}
+}
+
+func TestKeyManagerPersistsKeys(t *testing.T) {
+	dir := t.TempDir()
+	first, err := newKeyManager(dir, jose.RS256, []jose.SignatureAlgorithm{jose.ES256}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newKeyManager(dir, jose.RS256, []jose.SignatureAlgorithm{jose.ES256}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keyIDs(second.publicKeys()), keyIDs(first.publicKeys()); !equalStrings(got, want) {
+		t.Errorf("published keys after restart = %v, want %v", got, want)
+	}
+}
+
+func TestKeyManagerRotate(t *testing.T) {
+	const gracePeriod = time.Hour
+	tests := []struct {
+		name       string
+		retiredAgo time.Duration
+		restart    bool
+		wantOld    bool
+	}{
+		{name: "within grace period", retiredAgo: 0, wantOld: true},
+		{name: "grace period over", retiredAgo: 2 * gracePeriod, wantOld: false},
+		{name: "restart within grace period", retiredAgo: time.Minute, restart: true, wantOld: true},
+		{name: "restart after grace period", retiredAgo: 2 * gracePeriod, restart: true, wantOld: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			k, err := newKeyManager(dir, jose.RS256, nil, 0, gracePeriod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old, err := k.signingKey("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rotated, err := k.rotate(jose.RS256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotated.id == old.id {
+				t.Fatal("rotation kept the signing key")
+			}
+
+			retiredAt := time.Now().Add(-tt.retiredAgo)
+			retiredFile := filepath.Join(dir, retiredKeysDir, old.id+".json")
+			if tt.restart {
+				if err := os.Chtimes(retiredFile, retiredAt, retiredAt); err != nil {
+					t.Fatal(err)
+				}
+				if k, err = newKeyManager(dir, jose.RS256, nil, 0, gracePeriod); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				k.retired[0].retiredAt = retiredAt
+				k.mu.Lock()
+				k.pruneRetiredKeys()
+				k.mu.Unlock()
+			}
+
+			active, err := k.signingKey("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active.id != rotated.id {
+				t.Errorf("active key = %s, want %s", active.id, rotated.id)
+			}
+			want := []string{rotated.id}
+			if tt.wantOld {
+				want = append(want, old.id)
+			}
+			sort.Strings(want)
+			if got := keyIDs(k.publicKeys()); !equalStrings(got, want) {
+				t.Errorf("published keys = %v, want %v", got, want)
+			}
+			if _, err := os.Stat(retiredFile); (err == nil) != tt.wantOld {
+				t.Errorf("retired key file exists = %v, want %v", err == nil, tt.wantOld)
+			}
+		})
+	}
+}
+
+func keyIDs(keys []op.Key) []string {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
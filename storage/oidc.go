@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"golang.org/x/text/language"
@@ -15,23 +17,67 @@ const (
 )
 
 type AuthRequest struct {
-	ID            string
-	CreationDate  time.Time
-	ApplicationID string
-	CallbackURI   string
-	TransferState string
-	Prompt        []string
-	UiLocales     []language.Tag
-	LoginHint     string
-	MaxAuthAge    *time.Duration
-	UserID        string
-	Scopes        []string
-	ResponseType  oidc.ResponseType
-	Nonce         string
-	CodeChallenge *OIDCCodeChallenge
-
-	done     bool
-	authTime time.Time
+	ID            string             `json:"id"`
+	CreationDate  time.Time          `json:"creationDate"`
+	ApplicationID string             `json:"applicationID"`
+	CallbackURI   string             `json:"callbackURI"`
+	TransferState string             `json:"transferState"`
+	Prompt        []string           `json:"prompt"`
+	UiLocales     []language.Tag     `json:"uiLocales"`
+	LoginHint     string             `json:"loginHint"`
+	MaxAuthAge    *time.Duration     `json:"maxAuthAge"`
+	ACRValues     []string           `json:"acrValues"`
+	UserID        string             `json:"userID"`
+	Scopes        []string           `json:"scopes"`
+	ResponseType  oidc.ResponseType  `json:"responseType"`
+	Nonce         string             `json:"nonce"`
+	CodeChallenge *OIDCCodeChallenge `json:"codeChallenge"`
+	// SessionID is the session the user signed in with, if any.
+	SessionID string `json:"sessionID"`
+
+	done           bool
+	otpPending     bool
+	consentPending bool
+	authTime       time.Time
+	amr            []string
+	acr            string
+}
+
+// complete marks the request as done for the user, who authenticated at authTime with the given methods,
+// achieving the given acr.
+func (a *AuthRequest) complete(userID string, authTime time.Time, amr []string, acr string) {
+	a.UserID = userID
+	a.authTime = authTime
+	a.amr = amr
+	a.acr = acr
+	a.otpPending = false
+	a.done = true
+}
+
+// HasPrompt reports whether the client requested the given prompt value.
+func (a *AuthRequest) HasPrompt(prompt string) bool {
+	for _, p := range a.Prompt {
+		if p == prompt {
+			return true
+		}
+	}
+	return false
+}
+
+// satisfiedBy reports whether the user of the session may be signed in without logging in again,
+// i.e. the session belongs to the user of the id_token_hint, if any, and is at most max_age old.
+// prompt=login always requires the user to log in.
+func (a *AuthRequest) satisfiedBy(session *Session, now time.Time) bool {
+	if a.HasPrompt(oidc.PromptLogin) {
+		return false
+	}
+	if a.UserID != "" && a.UserID != session.UserID {
+		return false
+	}
+	if a.MaxAuthAge != nil && now.Sub(session.AuthTime) > *a.MaxAuthAge {
+		return false
+	}
+	return true
 }
 
 func (a *AuthRequest) GetID() string {
@@ -39,13 +85,15 @@ func (a *AuthRequest) GetID() string {
 }
 
 func (a *AuthRequest) GetACR() string {
-	return "" // we won't handle acr in this example
+	if a.done {
+		return a.acr
+	}
+	return ""
 }
 
 func (a *AuthRequest) GetAMR() []string {
-	// this example only uses password for authentication
 	if a.done {
-		return []string{"pwd"}
+		return a.amr
 	}
 	return nil
 }
@@ -94,12 +142,62 @@ func (a *AuthRequest) GetSubject() string {
 	return a.UserID
 }
 
+// Done reports whether the user authenticated and, if required, consented.
 func (a *AuthRequest) Done() bool {
-	return a.done
+	return a.done && !a.consentPending
+}
+
+// OTPPending reports whether the user entered the password, but has yet to enter a TOTP code.
+func (a *AuthRequest) OTPPending() bool {
+	return !a.done && a.otpPending
+}
+
+// ConsentPending reports whether the user authenticated, but has yet to consent to the requested scopes.
+func (a *AuthRequest) ConsentPending() bool {
+	return a.done && a.consentPending
+}
+
+// authRequestJSON includes the login state of AuthRequest, so that it can be stored in a Backend.
+type authRequestJSON struct {
+	*authRequestAlias
+	Done           bool      `json:"done"`
+	OTPPending     bool      `json:"otpPending"`
+	ConsentPending bool      `json:"consentPending"`
+	AuthTime       time.Time `json:"authTime"`
+	AMR            []string  `json:"amr"`
+	ACR            string    `json:"acr"`
+}
+
+type authRequestAlias AuthRequest
+
+func (a *AuthRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(authRequestJSON{
+		authRequestAlias: (*authRequestAlias)(a),
+		Done:             a.done,
+		OTPPending:       a.otpPending,
+		ConsentPending:   a.consentPending,
+		AuthTime:         a.authTime,
+		AMR:              a.amr,
+		ACR:              a.acr,
+	})
+}
+
+func (a *AuthRequest) UnmarshalJSON(data []byte) error {
+	aux := authRequestJSON{authRequestAlias: (*authRequestAlias)(a)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	a.done = aux.Done
+	a.otpPending = aux.OTPPending
+	a.consentPending = aux.ConsentPending
+	a.authTime = aux.AuthTime
+	a.amr = aux.AMR
+	a.acr = aux.ACR
+	return nil
 }
 
 func PromptToInternal(oidcPrompt oidc.SpaceDelimitedArray) []string {
-	prompts := make([]string, len(oidcPrompt))
+	prompts := make([]string, 0, len(oidcPrompt))
 	for _, oidcPrompt := range oidcPrompt {
 		switch oidcPrompt {
 		case oidc.PromptNone,
@@ -112,6 +210,15 @@ func PromptToInternal(oidcPrompt oidc.SpaceDelimitedArray) []string {
 	return prompts
 }
 
+// ACRValuesToInternal splits the space delimited acr_values, which the library doesn't.
+func ACRValuesToInternal(acrValues []string) []string {
+	var values []string
+	for _, value := range acrValues {
+		values = append(values, strings.Fields(value)...)
+	}
+	return values
+}
+
 func MaxAgeToInternal(maxAge *uint) *time.Duration {
 	if maxAge == nil {
 		return nil
@@ -130,6 +237,7 @@ func authRequestToInternal(authReq *oidc.AuthRequest, userID string) *AuthReques
 		UiLocales:     authReq.UILocales,
 		LoginHint:     authReq.LoginHint,
 		MaxAuthAge:    MaxAgeToInternal(authReq.MaxAge),
+		ACRValues:     ACRValuesToInternal(authReq.ACRValues),
 		UserID:        userID,
 		Scopes:        authReq.Scopes,
 		ResponseType:  authReq.ResponseType,
@@ -142,8 +250,8 @@ func authRequestToInternal(authReq *oidc.AuthRequest, userID string) *AuthReques
 }
 
 type OIDCCodeChallenge struct {
-	Challenge string
-	Method    string
+	Challenge string `json:"challenge"`
+	Method    string `json:"method"`
 }
 
 func CodeChallengeToOIDC(challenge *OIDCCodeChallenge) *oidc.CodeChallenge {
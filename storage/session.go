@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a single sign-on session of a user at the server, shared by all clients
+// the user signs in to with the same browser. Its ID is the sid claim of issued tokens.
+// A browser may hold sessions of several users, the first of which is active
+// (see ContextWithSessionIDs).
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userID"`
+	AuthTime   time.Time `json:"authTime"`
+	AMR        []string  `json:"amr"`
+	ACR        string    `json:"acr"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// expired reports whether the session is expired at the given time.
+func (s *Session) expired(now time.Time) bool {
+	return now.After(s.Expiration)
+}
+
+// CreateSession starts a session for the user who signed in with the auth request,
+// and records it in the request, so that its sid is included in the id_token.
+// Previous sessions of the user in the same browser end.
+func (s *Storage[T]) CreateSession(ctx context.Context, authRequestID string) (*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, err := s.authRequests.get(authRequestID)
+	if err != nil {
+		return nil, errors.New("request not found")
+	}
+	if !request.done {
+		return nil, errors.New("request is not authenticated")
+	}
+
+	sessions, err := s.browserSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.UserID == request.UserID {
+			if err := s.sessions.delete(session.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	session := &Session{
+		ID:         uuid.NewString(),
+		UserID:     request.UserID,
+		AuthTime:   request.authTime,
+		AMR:        request.amr,
+		ACR:        request.acr,
+		Expiration: time.Now().Add(s.sessionLifetime),
+	}
+	if err := s.sessions.put(session.ID, session); err != nil {
+		return nil, err
+	}
+	request.SessionID = session.ID
+	if err := s.authRequests.put(request.ID, request); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// SelectSession completes the auth request for the user of a session of the browser,
+// chosen on the account chooser (prompt=select_account).
+func (s *Storage[T]) SelectSession(ctx context.Context, authRequestID, sessionID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, err := s.authRequests.get(authRequestID)
+	if err != nil {
+		return errors.New("request not found")
+	}
+	sessions, err := s.browserSessions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID != sessionID {
+			continue
+		}
+		if !request.satisfiedBy(session, time.Now()) || !s.sessionSatisfiesACR(request, session) {
+			return errors.New("please sign in again")
+		}
+		if err := s.completeAuthRequest(request, session.UserID, session.AuthTime, session.AMR, session.ACR); err != nil {
+			return err
+		}
+		request.SessionID = session.ID
+		return s.authRequests.put(authRequestID, request)
+	}
+	return errors.New("session not found")
+}
+
+// Sessions returns the sessions of the browser the request is made with (see ContextWithSessionIDs),
+// which have not expired, starting with the active one.
+func (s *Storage[T]) Sessions(ctx context.Context) ([]*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.browserSessions(ctx)
+}
+
+// ActiveSession returns the active session of the browser the request is made with,
+// or nil if there is none or it has expired.
+func (s *Storage[T]) ActiveSession(ctx context.Context) (*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.userSession(ctx, "")
+}
+
+// browserSessions returns the unexpired sessions of ctx whose users still exist.
+// s.lock must be held.
+func (s *Storage[T]) browserSessions(ctx context.Context) ([]*Session, error) {
+	now := time.Now()
+	var sessions []*Session
+	for _, sessionID := range sessionIDsFromContext(ctx) {
+		session, err := s.sessions.get(sessionID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if session.expired(now) || s.userStore.GetUserByID(session.UserID) == nil {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// userSession returns the session of the user in the browser of ctx, or the active one
+// if userID is empty. It returns nil if there is none.
+// s.lock must be held.
+func (s *Storage[T]) userSession(ctx context.Context, userID string) (*Session, error) {
+	sessions, err := s.browserSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if userID == "" || session.UserID == userID {
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+// endSession removes the session of the user in the browser of ctx, or the active one
+// if userID is empty, and returns its ID, if any.
+// s.lock must be held.
+func (s *Storage[T]) endSession(ctx context.Context, userID string) (string, error) {
+	session, err := s.userSession(ctx, userID)
+	if err != nil || session == nil {
+		return "", err
+	}
+	if err := s.sessions.delete(session.ID); err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
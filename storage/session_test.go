@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+)
+
+func TestSessionReuse(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "session-client"
+	otherClientID := "session-other-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	registerTestClient(t, WebClient(otherClientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	session, withSession := startSession(t, s, ctx, clientID, "alice")
+
+	// another client is signed in from the session, with the methods of the original login
+	authReq, err := s.CreateAuthRequest(withSession, testAuthRequest(otherClientID), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := authReq.(*AuthRequest)
+	if !request.Done() || request.GetSubject() != session.UserID || request.SessionID != session.ID {
+		t.Fatalf("Done() = %v, subject = %q, sid = %q, want done for %q in %q",
+			request.Done(), request.GetSubject(), request.SessionID, session.UserID, session.ID)
+	}
+	if !request.GetAuthTime().Equal(session.AuthTime) || !equalStrings(request.GetAMR(), session.AMR) || request.GetACR() != session.ACR {
+		t.Errorf("auth_time = %v, amr = %v, acr = %q, want those of the session %v, %v, %q",
+			request.GetAuthTime(), request.GetAMR(), request.GetACR(), session.AuthTime, session.AMR, session.ACR)
+	}
+
+	// the id_token_hint of another user requires logging in
+	authReq, err = s.CreateAuthRequest(withSession, testAuthRequest(otherClientID), testUsers["bob"].ID_)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authReq.Done() {
+		t.Error("request for the user of the id_token_hint was completed from the session of another user")
+	}
+
+	// signing in again replaces the session of the user in the browser
+	request = signIn(t, s, withSession, testAuthRequest(clientID), "alice")
+	renewed, err := s.CreateSession(withSession, request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.sessions.get(session.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("previous session: error = %v, want ErrNotFound", err)
+	}
+
+	// expired sessions aren't reused
+	renewed.Expiration = time.Now().Add(-time.Second)
+	if err := s.sessions.put(renewed.ID, renewed); err != nil {
+		t.Fatal(err)
+	}
+	authReq, err = s.CreateAuthRequest(ContextWithSessionIDs(ctx, []string{renewed.ID}), testAuthRequest(otherClientID), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authReq.Done() {
+		t.Error("request was completed from an expired session")
+	}
+}
+
+func TestSelectSessionMaxAge(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "select-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	session, withSession := startSession(t, s, ctx, clientID, "alice")
+	// sign in an hour ago
+	session.AuthTime = time.Now().Add(-time.Hour)
+	if err := s.sessions.put(session.ID, session); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		maxAge  uint
+		wantErr bool
+	}{
+		{name: "session within max_age", maxAge: 7200},
+		{name: "session older than max_age", maxAge: 60, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testAuthRequest(clientID)
+			req.Prompt = oidc.SpaceDelimitedArray{oidc.PromptSelectAccount}
+			req.MaxAge = &tt.maxAge
+			authReq, err := s.CreateAuthRequest(withSession, req, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if authReq.Done() {
+				t.Fatal("prompt=select_account request was completed without choosing a session")
+			}
+
+			err = s.SelectSession(withSession, authReq.GetID(), session.ID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectSession() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			request, err := s.authRequests.get(authReq.GetID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if request.Done() == tt.wantErr {
+				t.Errorf("Done() = %v, want %v", request.Done(), !tt.wantErr)
+			}
+		})
+	}
+}
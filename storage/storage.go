@@ -2,8 +2,8 @@ package storage
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/rsa"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"log"
@@ -35,20 +35,31 @@ var (
 )
 
 // storage implements the op.Storage interface
-// typically you would implement this as a layer on top of your database
-// for simplicity this example keeps everything in-memory
+// its state (tokens, auth requests, sessions, ...) is kept in a pluggable Backend (see WithBackend),
+// in memory by default or in a bbolt database (see NewBoltBackend) to survive restarts
 type Storage[T User] struct {
 	lock                       sync.Mutex
-	authRequests               map[string]*AuthRequest
-	codes                      map[string]string
-	tokens                     map[string]*Token
-	clients                    map[string]*Client
+	backend                    Backend
+	authRequests               table[AuthRequest]
+	codes                      table[authCode]
+	tokens                     table[Token]
+	clientsDir                 string
+	pathPrefix                 string
+	fileClientIDs              map[string]struct{}
 	userStore                  UserStore[T]
 	services                   map[string]Service
-	refreshTokens              map[string]*RefreshToken
-	signingKey                 signingKey
-	deviceCodes                map[string]deviceAuthorizationEntry
-	userCodes                  map[string]string
+	refreshTokens              table[RefreshToken]
+	keys                       *keyManager
+	janitorInterval            time.Duration
+	authRequestLifetime        time.Duration
+	codeLifetime               time.Duration
+	tokenLifetimes             TokenLifetimes
+	deviceCodes                table[deviceAuthorizationEntry]
+	userCodes                  table[string]
+	sessions                   table[Session]
+	grants                     table[Grant]
+	sessionLifetime            time.Duration
+	mfaACRValues               []string
 	serviceUsers               map[string]*Client
 	setUserInfoFunc            SetUserInfoFunc[T]
 	getPrivateClaimsFromScopes GetPrivateClaimsFromScopesFunc
@@ -59,58 +70,190 @@ type (
 	GetPrivateClaimsFromScopesFunc func(ctx context.Context, userID, clientID string, scopes []string) (claims map[string]interface{}, err error)
 )
 
-type signingKey struct {
-	id        string
-	algorithm jose.SignatureAlgorithm
-	key       *rsa.PrivateKey
+// Option configures optional Storage behaviour.
+type Option func(*options)
+
+type options struct {
+	backend             Backend
+	pathPrefix          string
+	clientsDir          string
+	signingAlgorithm    jose.SignatureAlgorithm
+	keysDir             string
+	keyRotationInterval time.Duration
+	keyGracePeriod      time.Duration
+	janitorInterval     time.Duration
+	authRequestLifetime time.Duration
+	codeLifetime        time.Duration
+	tokenLifetimes      TokenLifetimes
+	sessionLifetime     time.Duration
+	mfaACRValues        []string
 }
 
-func (s *signingKey) SignatureAlgorithm() jose.SignatureAlgorithm {
-	return s.algorithm
+// WithBackend sets the backend the state (tokens, auth requests, ...) is kept in.
+// Default: NewMemoryBackend().
+func WithBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithPathPrefix sets the domain path prefix used for the login URL of clients created by the storage.
+func WithPathPrefix(pathPrefix string) Option {
+	return func(o *options) {
+		o.pathPrefix = pathPrefix
+	}
 }
 
-func (s *signingKey) Key() interface{} {
-	return s.key
+// WithClientsDir loads clients from the JSON files in dir in addition to the registered ones,
+// and reloads them whenever a file is modified.
+func WithClientsDir(dir string) Option {
+	return func(o *options) {
+		o.clientsDir = dir
+	}
 }
 
-func (s *signingKey) ID() string {
-	return s.id
+// WithSigningAlgorithm sets the default algorithm used to sign tokens,
+// for clients without an id_token_signed_response_alg. Default: RS256.
+// See SupportedSigningAlgorithms; EdDSA can't be the default, as it is restricted to some clients.
+func WithSigningAlgorithm(alg jose.SignatureAlgorithm) Option {
+	return func(o *options) {
+		if alg != "" {
+			o.signingAlgorithm = alg
+		}
+	}
 }
 
-type publicKey struct {
-	signingKey
+// WithKeysDir loads signing keys from dir, generating them on first run,
+// so that issued tokens remain valid across restarts.
+func WithKeysDir(dir string) Option {
+	return func(o *options) {
+		o.keysDir = dir
+	}
 }
 
-func (s *publicKey) ID() string {
-	return s.id
+// WithKeyRotation rotates the signing key every interval once StartKeyRotation is called.
+// Public keys of rotated signing keys remain published for gracePeriod, which should
+// be longer than the lifetime of any issued token.
+// A zero interval disables scheduled rotation while still allowing on demand rotation.
+// A zero gracePeriod keeps the default of one hour.
+func WithKeyRotation(interval, gracePeriod time.Duration) Option {
+	return func(o *options) {
+		o.keyRotationInterval = interval
+		if gracePeriod > 0 {
+			o.keyGracePeriod = gracePeriod
+		}
+	}
 }
 
-func (s *publicKey) Algorithm() jose.SignatureAlgorithm {
-	return s.algorithm
+// WithJanitor sets how often expired entries are purged once StartJanitor is called,
+// and how long auth requests may stay pending before they are considered abandoned.
+// Zero values keep the defaults of one minute and 30 minutes respectively.
+func WithJanitor(interval, authRequestLifetime time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.janitorInterval = interval
+		}
+		if authRequestLifetime > 0 {
+			o.authRequestLifetime = authRequestLifetime
+		}
+	}
 }
 
-func (s *publicKey) Use() string {
-	return "sig"
+// WithCodeLifetime sets how long authorization codes can be exchanged for tokens.
+// Default: 60s.
+func WithCodeLifetime(lifetime time.Duration) Option {
+	return func(o *options) {
+		if lifetime > 0 {
+			o.codeLifetime = lifetime
+		}
+	}
 }
 
-func (s *publicKey) Key() interface{} {
-	return &s.key.PublicKey
+// WithTokenLifetimes sets the default lifetimes of issued tokens, which clients may override.
+// Zero values keep the defaults of 5m for access tokens, 5h for refresh tokens and 1h for id_tokens.
+// The idle lifetime of refresh tokens is disabled by default.
+func WithTokenLifetimes(lifetimes TokenLifetimes) Option {
+	return func(o *options) {
+		o.tokenLifetimes = lifetimes.withDefaults(defaultTokenLifetimes)
+	}
 }
 
-func NewStorage[T User](userStore UserStore[T], setUserInfoFunc SetUserInfoFunc[T], getPrivateClaimsFromScopes GetPrivateClaimsFromScopesFunc) *Storage[T] {
-	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+// WithSessionLifetime sets how long users stay signed in to the server, i.e. how long
+// authorization requests complete without login for clients they didn't sign in to yet.
+// Default: 24h.
+func WithSessionLifetime(lifetime time.Duration) Option {
+	return func(o *options) {
+		if lifetime > 0 {
+			o.sessionLifetime = lifetime
+		}
+	}
+}
+
+func NewStorage[T User](userStore UserStore[T], setUserInfoFunc SetUserInfoFunc[T], getPrivateClaimsFromScopes GetPrivateClaimsFromScopesFunc, opts ...Option) (*Storage[T], error) {
 	if setUserInfoFunc == nil {
-		log.Fatalf("NewStorage: missing setUserInfoFunc")
+		return nil, errors.New("missing setUserInfoFunc")
 	}
 	if getPrivateClaimsFromScopes == nil {
-		log.Fatalf("NewStorage: missing getPrivateClaimsFromScopes")
-	}
-	return &Storage[T]{
-		authRequests:               make(map[string]*AuthRequest),
-		codes:                      make(map[string]string),
-		tokens:                     make(map[string]*Token),
-		refreshTokens:              make(map[string]*RefreshToken),
-		clients:                    clients,
+		return nil, errors.New("missing getPrivateClaimsFromScopes")
+	}
+	o := &options{
+		signingAlgorithm:    jose.RS256,
+		keyGracePeriod:      time.Hour,
+		janitorInterval:     time.Minute,
+		authRequestLifetime: 30 * time.Minute,
+		codeLifetime:        time.Minute,
+		tokenLifetimes:      defaultTokenLifetimes,
+		sessionLifetime:     24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !hasClaimHash(o.signingAlgorithm) {
+		return nil, fmt.Errorf("signing algorithm %s can't be the default, since at_hash and c_hash can't be computed for it; "+
+			"set it as idTokenSignedResponseAlg of id_token only clients instead", o.signingAlgorithm)
+	}
+	if o.backend == nil {
+		o.backend = NewMemoryBackend()
+	}
+	fileClientIDs := make(map[string]struct{})
+	if o.clientsDir != "" {
+		fileClients, err := LoadClientsFromJSON(o.clientsDir, o.pathPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("could not load clients from JSON: %w", err)
+		}
+		clientsLock.Lock()
+		for id, client := range fileClients {
+			if _, exists := clients[id]; exists {
+				clientsLock.Unlock()
+				return nil, fmt.Errorf("client with ID %s already exists", id)
+			}
+			clients[id] = client
+			fileClientIDs[id] = struct{}{}
+		}
+		clientsLock.Unlock()
+	}
+	// create keys for every algorithm in use upfront, so that they are published right away
+	clientAlgs := []jose.SignatureAlgorithm{}
+	clientsLock.RLock()
+	for _, client := range clients {
+		if client.idTokenSignedResponseAlg != "" {
+			clientAlgs = append(clientAlgs, client.idTokenSignedResponseAlg)
+		}
+	}
+	clientsLock.RUnlock()
+	keys, err := newKeyManager(o.keysDir, o.signingAlgorithm, clientAlgs, o.keyRotationInterval, o.keyGracePeriod)
+	if err != nil {
+		return nil, fmt.Errorf("could not load signing keys: %w", err)
+	}
+	s := &Storage[T]{
+		backend:                    o.backend,
+		authRequests:               table[AuthRequest]{o.backend, bucketAuthRequests},
+		codes:                      table[authCode]{o.backend, bucketCodes},
+		tokens:                     table[Token]{o.backend, bucketTokens},
+		refreshTokens:              table[RefreshToken]{o.backend, bucketRefreshTokens},
+		clientsDir:                 o.clientsDir,
+		pathPrefix:                 o.pathPrefix,
+		fileClientIDs:              fileClientIDs,
 		userStore:                  userStore,
 		setUserInfoFunc:            setUserInfoFunc,
 		getPrivateClaimsFromScopes: getPrivateClaimsFromScopes,
@@ -121,13 +264,17 @@ func NewStorage[T User](userStore UserStore[T], setUserInfoFunc SetUserInfoFunc[
 				},
 			},
 		},
-		signingKey: signingKey{
-			id:        uuid.NewString(),
-			algorithm: jose.RS256,
-			key:       key,
-		},
-		deviceCodes: make(map[string]deviceAuthorizationEntry),
-		userCodes:   make(map[string]string),
+		keys:                keys,
+		janitorInterval:     o.janitorInterval,
+		authRequestLifetime: o.authRequestLifetime,
+		codeLifetime:        o.codeLifetime,
+		tokenLifetimes:      o.tokenLifetimes,
+		deviceCodes:         table[deviceAuthorizationEntry]{o.backend, bucketDeviceCodes},
+		userCodes:           table[string]{o.backend, bucketUserCodes},
+		sessions:            table[Session]{o.backend, bucketSessions},
+		grants:              table[Grant]{o.backend, bucketGrants},
+		sessionLifetime:     o.sessionLifetime,
+		mfaACRValues:        o.mfaACRValues,
 		serviceUsers: map[string]*Client{
 			"sid1": {
 				id:     "sid1",
@@ -139,14 +286,55 @@ func NewStorage[T User](userStore UserStore[T], setUserInfoFunc SetUserInfoFunc[
 			},
 		},
 	}
+
+	if o.clientsDir != "" {
+		go watchFolder(o.clientsDir, "clients", s.reloadClients)
+	}
+
+	return s, nil
+}
+
+// reloadClients replaces the clients loaded from JSON files with the current file contents.
+// Previously loaded clients are kept if any file is invalid.
+func (s *Storage[T]) reloadClients() error {
+	fileClients, err := LoadClientsFromJSON(s.clientsDir, s.pathPrefix)
+	if err != nil {
+		return err
+	}
+	for _, client := range fileClients {
+		if _, err := s.keys.signingKey(client.idTokenSignedResponseAlg); err != nil {
+			return err
+		}
+	}
+
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	for id := range fileClients {
+		if _, exists := clients[id]; exists {
+			if _, fromFile := s.fileClientIDs[id]; !fromFile {
+				return fmt.Errorf("client with ID %s already exists", id)
+			}
+		}
+	}
+	for id := range s.fileClientIDs {
+		delete(clients, id)
+	}
+	s.fileClientIDs = make(map[string]struct{})
+	for id, client := range fileClients {
+		clients[id] = client
+		s.fileClientIDs[id] = struct{}{}
+	}
+
+	return nil
 }
 
 // CheckUsernamePassword implements the `authenticate` interface of the login
 func (s *Storage[T]) CheckUsernamePassword(username, password, id string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	request, ok := s.authRequests[id]
-	if !ok {
+	request, err := s.authRequests.get(id)
+	if err != nil {
 		return fmt.Errorf("request not found")
 	}
 
@@ -157,13 +345,22 @@ func (s *Storage[T]) CheckUsernamePassword(username, password, id string) error
 	if user != nil && (*user).Password() == password {
 		// be sure to set user id into the auth request after the user was checked,
 		// so that you'll be able to get more information about the user after the login
-		request.UserID = (*user).ID()
-
+		//
 		// you will have to change some state on the request to guide the user through possible multiple steps of the login process
 		// in this example we'll simply check the username / password and set a boolean to true
 		// therefore we will also just check this boolean if the request / login has been finished
-		request.done = true
-		return nil
+		//
+		// users with a TOTP secret have to enter a code next (see CheckTOTPCode)
+		if userTOTPSecret(user) != "" {
+			request.UserID = (*user).ID()
+			request.otpPending = true
+			return s.authRequests.put(id, request)
+		}
+		amr := []string{"pwd"}
+		if err := s.completeAuthRequest(request, (*user).ID(), time.Now(), amr, s.acrFor(request, (*user).ID(), amr)); err != nil {
+			return err
+		}
+		return s.authRequests.put(id, request)
 	}
 	return fmt.Errorf("username or password wrong")
 }
@@ -185,20 +382,43 @@ func (s *Storage[T]) CreateAuthRequest(ctx context.Context, authReq *oidc.AuthRe
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if len(authReq.Prompt) == 1 && authReq.Prompt[0] == "none" {
-		// With prompt=none, there is no way for the user to log in
-		// so return error right away.
-		return nil, oidc.ErrLoginRequired()
-	}
-
 	// typically, you'll fill your storage / storage model with the information of the passed object
 	request := authRequestToInternal(authReq, userID)
 
 	// you'll also have to create a unique id for the request (this might be done by your database; we'll use a uuid)
 	request.ID = uuid.NewString()
 
-	// and save it in your database (for demonstration purposed we will use a simple map)
-	s.authRequests[request.ID] = request
+	// users who already signed in with this browser don't have to do so again, unless the client
+	// asks for a more recent login or an acr the session lacks, or lets the user choose the account; the session of the user
+	// of the id_token_hint or login_hint is used if there is one
+	sessionUserID := userID
+	if sessionUserID == "" {
+		if _, user := FindUserByLoginHint(s.userStore.Users(), request.LoginHint); user != nil {
+			sessionUserID = (*user).ID()
+		}
+	}
+	session, err := s.userSession(ctx, sessionUserID)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil && !request.HasPrompt(oidc.PromptSelectAccount) && request.satisfiedBy(session, time.Now()) && s.sessionSatisfiesACR(request, session) {
+		if err := s.completeAuthRequest(request, session.UserID, session.AuthTime, session.AMR, session.ACR); err != nil {
+			return nil, err
+		}
+		request.SessionID = session.ID
+	} else if request.HasPrompt(oidc.PromptNone) {
+		// with prompt=none, there is no way for the user to log in
+		// so return error right away
+		return nil, oidc.ErrLoginRequired()
+	}
+	if request.consentPending && request.HasPrompt(oidc.PromptNone) {
+		return nil, errConsentRequired()
+	}
+
+	// and save it in your database (for demonstration purposes we will use the configured backend)
+	if err := s.authRequests.put(request.ID, request); err != nil {
+		return nil, err
+	}
 
 	// finally, return the request (which implements the AuthRequest interface of the OP
 	return request, nil
@@ -209,8 +429,8 @@ func (s *Storage[T]) CreateAuthRequest(ctx context.Context, authReq *oidc.AuthRe
 func (s *Storage[T]) AuthRequestByID(ctx context.Context, id string) (op.AuthRequest, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	request, ok := s.authRequests[id]
-	if !ok {
+	request, err := s.authRequests.get(id)
+	if err != nil {
 		return nil, fmt.Errorf("request not found")
 	}
 	return request, nil
@@ -219,17 +439,41 @@ func (s *Storage[T]) AuthRequestByID(ctx context.Context, id string) (op.AuthReq
 // AuthRequestByCode implements the op.Storage interface
 // it will be called after parsing and validation of the token request (in an authorization code flow)
 func (s *Storage[T]) AuthRequestByCode(ctx context.Context, code string) (op.AuthRequest, error) {
-	// for this example we read the id by code and then get the request by id
-	requestID, ok := func() (string, bool) {
-		s.lock.Lock()
-		defer s.lock.Unlock()
-		requestID, ok := s.codes[code]
-		return requestID, ok
-	}()
-	if !ok {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	authCode, err := s.codes.get(code)
+	if err != nil {
+		return nil, fmt.Errorf("code invalid or expired")
+	}
+	if !authCode.presentedBy(clientIDFromContext(ctx), codeVerifierFromContext(ctx)) {
+		return nil, fmt.Errorf("code was not issued to this client or code_verifier is invalid")
+	}
+	if authCode.Used {
+		// a code must only be used once. If it is replayed, the tokens already issued
+		// from it must be considered compromised (RFC 6749 section 4.1.2)
+		revoked, err := s.revokeAuthRequestTokens(authCode.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("authorization code replayed: revoked %d tokens and %d refresh tokens of auth request %s",
+			revoked.Tokens, revoked.RefreshTokens, authCode.RequestID)
+		return nil, fmt.Errorf("code already used")
+	}
+	if authCode.expired(time.Now(), s.codeLifetime) {
+		if err := s.codes.delete(code); err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("code invalid or expired")
 	}
-	return s.AuthRequestByID(ctx, requestID)
+	request, err := s.authRequests.get(authCode.RequestID)
+	if err != nil {
+		return nil, fmt.Errorf("request not found")
+	}
+	authCode.Used = true
+	if err := s.codes.put(code, authCode); err != nil {
+		return nil, err
+	}
+	return request, nil
 }
 
 // SaveAuthCode implements the op.Storage interface
@@ -239,8 +483,16 @@ func (s *Storage[T]) SaveAuthCode(ctx context.Context, id string, code string) e
 	// for this example we'll just save the authRequestID to the code
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.codes[code] = id
-	return nil
+	request, err := s.authRequests.get(id)
+	if err != nil {
+		return fmt.Errorf("request not found")
+	}
+	return s.codes.put(code, &authCode{
+		RequestID:     id,
+		ClientID:      request.ApplicationID,
+		CodeChallenge: request.CodeChallenge,
+		IssuedAt:      time.Now(),
+	})
 }
 
 // DeleteAuthRequest implements the op.Storage interface
@@ -248,17 +500,16 @@ func (s *Storage[T]) SaveAuthCode(ctx context.Context, id string, code string) e
 // - authentication request (in an implicit flow)
 // - token request (in an authorization code flow)
 func (s *Storage[T]) DeleteAuthRequest(ctx context.Context, id string) error {
-	// you can simply delete all reference to the auth request
+	// used codes are kept for their replay window to detect replays (see codeReplayWindow)
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	delete(s.authRequests, id)
-	for code, requestID := range s.codes {
-		if id == requestID {
-			delete(s.codes, code)
-			return nil
-		}
+	if err := s.authRequests.delete(id); err != nil {
+		return err
 	}
-	return nil
+	_, err := s.codes.deleteWhere(func(_ string, authCode *authCode) bool {
+		return authCode.RequestID == id && !authCode.Used
+	})
+	return err
 }
 
 // CreateAccessToken implements the op.Storage interface
@@ -273,10 +524,11 @@ func (s *Storage[T]) CreateAccessToken(ctx context.Context, request op.TokenRequ
 		applicationID = req.GetClientID()
 	}
 
-	token, err := s.accessToken(applicationID, "", request.GetSubject(), request.GetAudience(), request.GetScopes())
+	token, err := s.accessToken(applicationID, "", authRequestIDFromRequest(request), request.GetSubject(), acrFromRequest(request), request.GetAudience(), request.GetScopes())
 	if err != nil {
 		return "", time.Time{}, err
 	}
+	recordAccessToken(ctx, token.ID)
 	return token.ID, token.Expiration, nil
 }
 
@@ -290,18 +542,20 @@ func (s *Storage[T]) CreateAccessAndRefreshTokens(ctx context.Context, request o
 
 	// get the information depending on the request type / implementation
 	applicationID, authTime, amr := getInfoFromRequest(request)
+	authRequestID := authRequestIDFromRequest(request)
 
 	// if currentRefreshToken is empty (Code Flow) we will have to create a new refresh token
 	if currentRefreshToken == "" {
 		refreshTokenID := uuid.NewString()
-		accessToken, err := s.accessToken(applicationID, refreshTokenID, request.GetSubject(), request.GetAudience(), request.GetScopes())
+		accessToken, err := s.accessToken(applicationID, refreshTokenID, authRequestID, request.GetSubject(), acrFromRequest(request), request.GetAudience(), request.GetScopes())
 		if err != nil {
 			return "", "", time.Time{}, err
 		}
-		refreshToken, err := s.createRefreshToken(accessToken, amr, authTime)
+		refreshToken, err := s.createRefreshToken(accessToken, amr, authTime, sessionIDFromRequest(request))
 		if err != nil {
 			return "", "", time.Time{}, err
 		}
+		recordAccessToken(ctx, accessToken.ID)
 		return accessToken.ID, refreshToken, accessToken.Expiration, nil
 	}
 
@@ -311,10 +565,11 @@ func (s *Storage[T]) CreateAccessAndRefreshTokens(ctx context.Context, request o
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
-	accessToken, err := s.accessToken(applicationID, refreshTokenID, request.GetSubject(), request.GetAudience(), request.GetScopes())
+	accessToken, err := s.accessToken(applicationID, refreshTokenID, authRequestID, request.GetSubject(), acrFromRequest(request), request.GetAudience(), request.GetScopes())
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
+	recordAccessToken(ctx, accessToken.ID)
 	return accessToken.ID, refreshToken, accessToken.Expiration, nil
 }
 
@@ -323,15 +578,16 @@ func (s *Storage[T]) exchangeRefreshToken(ctx context.Context, request op.TokenE
 	authTime := request.GetAuthTime()
 
 	refreshTokenID := uuid.NewString()
-	accessToken, err := s.accessToken(applicationID, refreshTokenID, request.GetSubject(), request.GetAudience(), request.GetScopes())
+	accessToken, err := s.accessToken(applicationID, refreshTokenID, "", request.GetSubject(), "", request.GetAudience(), request.GetScopes())
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
 
-	refreshToken, err := s.createRefreshToken(accessToken, nil, authTime)
+	refreshToken, err := s.createRefreshToken(accessToken, nil, authTime, "")
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
+	recordAccessToken(ctx, accessToken.ID)
 
 	return accessToken.ID, refreshToken, accessToken.Expiration, nil
 }
@@ -341,32 +597,70 @@ func (s *Storage[T]) exchangeRefreshToken(ctx context.Context, request op.TokenE
 func (s *Storage[T]) TokenRequestByRefreshToken(ctx context.Context, refreshToken string) (op.RefreshTokenRequest, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	token, ok := s.refreshTokens[refreshToken]
-	if !ok {
+	token, err := s.refreshTokens.get(refreshToken)
+	if err != nil {
 		return nil, fmt.Errorf("invalid refresh_token")
 	}
+	// the library responds with invalid_grant on error
+	if token.expired(time.Now()) {
+		return nil, fmt.Errorf("refresh_token has expired")
+	}
+	if err := s.checkRefreshTokenReuse(token); err != nil {
+		return nil, err
+	}
 	return RefreshTokenRequestFromBusiness(token), nil
 }
 
 // TerminateSession implements the op.Storage interface
-// it will be called after the user signed out, therefore the access and refresh token of the user of this client must be removed
+// it will be called after the user signed out, therefore the access and refresh tokens of the user are removed
+// for every client the user has a session with, which is notified via back-channel logout.
+// The session of the user in the browser (see ContextWithSessionIDs) ends as well.
 func (s *Storage[T]) TerminateSession(ctx context.Context, userID string, clientID string) error {
+	sessionID, clientIDs, err := s.terminateSession(ctx, userID, clientID)
+	if err != nil {
+		return err
+	}
+	if userID != "" {
+		s.backChannelLogout(op.IssuerFromContext(ctx), userID, sessionID, clientIDs)
+	}
+	return nil
+}
+
+// terminateSession removes the session of ctx and the tokens of the user for the client signed out of
+// and the other clients the user had sessions with, and returns the ID of the session and those clients.
+func (s *Storage[T]) terminateSession(ctx context.Context, userID string, clientID string) (string, []string, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	for _, token := range s.tokens {
-		if token.ApplicationID == clientID && token.Subject == userID {
-			delete(s.tokens, token.ID)
-			delete(s.refreshTokens, token.RefreshTokenID)
-		}
+	sessionID, err := s.endSession(ctx, userID)
+	if err != nil {
+		return "", nil, err
 	}
-	return nil
+	clientIDs, err := s.sessionClients(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	// clients told the user signed out must not keep using tokens of the user
+	signedOut := func(applicationID, subject string) bool {
+		return subject == userID && (applicationID == clientID || containsString(clientIDs, applicationID))
+	}
+	if _, err := s.refreshTokens.deleteWhere(func(_ string, refreshToken *RefreshToken) bool {
+		return signedOut(refreshToken.ApplicationID, refreshToken.UserID)
+	}); err != nil {
+		return "", nil, err
+	}
+	if _, err := s.tokens.deleteWhere(func(_ string, token *Token) bool {
+		return signedOut(token.ApplicationID, token.Subject)
+	}); err != nil {
+		return "", nil, err
+	}
+	return sessionID, clientIDs, nil
 }
 
 // GetRefreshTokenInfo looks up a refresh token and returns the token id and user id.
 // If given something that is not a refresh token, it must return error.
 func (s *Storage[T]) GetRefreshTokenInfo(ctx context.Context, clientID string, token string) (userID string, tokenID string, err error) {
-	refreshToken, ok := s.refreshTokens[token]
-	if !ok {
+	refreshToken, err := s.refreshTokens.get(token)
+	if err != nil {
 		return "", "", op.ErrInvalidRefreshToken
 	}
 	return refreshToken.UserID, refreshToken.ID, nil
@@ -378,18 +672,20 @@ func (s *Storage[T]) RevokeToken(ctx context.Context, tokenIDOrToken string, use
 	// a single token was requested to be removed
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	accessToken, ok := s.tokens[tokenIDOrToken] // tokenID
-	if ok {
+	accessToken, err := s.tokens.get(tokenIDOrToken) // tokenID
+	if err == nil {
 		if accessToken.ApplicationID != clientID {
 			return oidc.ErrInvalidClient().WithDescription("token was not issued for this client")
 		}
 		// if it is an access token, just remove it
 		// you could also remove the corresponding refresh token if really necessary
-		delete(s.tokens, accessToken.ID)
+		if err := s.tokens.delete(accessToken.ID); err != nil {
+			return oidc.ErrServerError().WithParent(err)
+		}
 		return nil
 	}
-	refreshToken, ok := s.refreshTokens[tokenIDOrToken] // token
-	if !ok {
+	refreshToken, err := s.refreshTokens.get(tokenIDOrToken) // token
+	if err != nil {
 		// if the token is neither an access nor a refresh token, just ignore it, the expected behaviour of
 		// being not valid (anymore) is achieved
 		return nil
@@ -398,12 +694,8 @@ func (s *Storage[T]) RevokeToken(ctx context.Context, tokenIDOrToken string, use
 		return oidc.ErrInvalidClient().WithDescription("token was not issued for this client")
 	}
 	// if it is a refresh token, you will have to remove the access token as well
-	delete(s.refreshTokens, refreshToken.ID)
-	for _, accessToken := range s.tokens {
-		if accessToken.RefreshTokenID == refreshToken.ID {
-			delete(s.tokens, accessToken.ID)
-			return nil
-		}
+	if _, err := s.revokeRefreshToken(refreshToken.ID); err != nil {
+		return oidc.ErrServerError().WithParent(err)
 	}
 	return nil
 }
@@ -411,46 +703,190 @@ func (s *Storage[T]) RevokeToken(ctx context.Context, tokenIDOrToken string, use
 // SigningKey implements the op.Storage interface
 // it will be called when creating the OpenID Provider
 func (s *Storage[T]) SigningKey(ctx context.Context) (op.SigningKey, error) {
-	// in this example the signing key is a static rsa.PrivateKey and the algorithm used is RS256
-	// you would obviously have a more complex implementation and store / retrieve the key from your database as well
-	return &s.signingKey, nil
+	// the op.Storage interface provides no client, so the client specific algorithm
+	// is taken from the context, if set (see ContextWithClientID)
+	var alg jose.SignatureAlgorithm
+	if clientID := clientIDFromContext(ctx); clientID != "" {
+		if client, ok := getClient(clientID); ok {
+			alg = client.idTokenSignedResponseAlg
+		}
+	}
+	// the active key is switched atomically on rotation
+	return s.keys.signingKey(alg)
 }
 
 // SignatureAlgorithms implements the op.Storage interface
 // it will be called to get the sign
 func (s *Storage[T]) SignatureAlgorithms(context.Context) ([]jose.SignatureAlgorithm, error) {
-	return []jose.SignatureAlgorithm{s.signingKey.algorithm}, nil
+	return s.keys.algorithms(), nil
 }
 
 // KeySet implements the op.Storage interface
 // it will be called to get the current (public) keys, among others for the keys_endpoint or for validating access_tokens on the userinfo_endpoint, ...
 func (s *Storage[T]) KeySet(ctx context.Context) ([]op.Key, error) {
-	// as mentioned above, this example only has a single signing key without key rotation,
-	// so it will directly use its public key
-	//
-	// when using key rotation you typically would store the public keys alongside the private keys in your database
-	// and give both of them an expiration date, with the public key having a longer lifetime
-	return []op.Key{&publicKey{s.signingKey}}, nil
+	// the public keys of rotated signing keys are kept until their grace period ends,
+	// so that previously issued tokens can still be verified
+	return s.keys.publicKeys(), nil
+}
+
+// Close releases the backend the state is kept in.
+func (s *Storage[T]) Close() error {
+	return s.backend.Close()
+}
+
+// StartKeyRotation rotates the signing key on the schedule set via WithKeyRotation
+// until ctx is done.
+func (s *Storage[T]) StartKeyRotation(ctx context.Context) {
+	go s.keys.run(ctx)
+}
+
+// RotateSigningKeys replaces the active signing key of every algorithm in use on demand
+// and returns the new public keys.
+func (s *Storage[T]) RotateSigningKeys(ctx context.Context) ([]op.Key, error) {
+	return s.keys.rotateAll()
+}
+
+// RegisterClient creates a client from the given configuration at runtime, e.g. via
+// dynamic client registration. A random ID and registration access token are generated,
+// as well as a secret if the auth method requires one.
+func (s *Storage[T]) RegisterClient(ctx context.Context, config ClientConfig) (*Client, error) {
+	config.ID = uuid.NewString()
+	client, err := s.newRegisteredClient(config, "")
+	if err != nil {
+		return nil, err
+	}
+	registrationAccessToken, err := randomSecret()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate registration access token: %w", err)
+	}
+	client.registrationAccessToken = registrationAccessToken
+
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	clients[client.id] = client
+
+	return client, nil
+}
+
+// RegisteredClient returns the dynamically registered client for the given
+// registration access token (RFC 7592).
+func (s *Storage[T]) RegisteredClient(ctx context.Context, clientID, registrationAccessToken string) (*Client, error) {
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
+	return registeredClient(clientID, registrationAccessToken)
+}
+
+// registeredClient returns the dynamically registered client for the given registration access token.
+// clientsLock must be held.
+func registeredClient(clientID, registrationAccessToken string) (*Client, error) {
+	client, ok := clients[clientID]
+	if !ok || client.registrationAccessToken == "" ||
+		subtle.ConstantTimeCompare([]byte(client.registrationAccessToken), []byte(registrationAccessToken)) != 1 {
+		return nil, errors.New("invalid registration access token")
+	}
+	return client, nil
+}
+
+// UpdateClient replaces the configuration of a dynamically registered client (RFC 7592)
+// if the registration access token is still valid for it, e.g. the client wasn't deleted meanwhile.
+// The client ID, registration access token and existing secret are kept.
+func (s *Storage[T]) UpdateClient(ctx context.Context, clientID, registrationAccessToken string, config ClientConfig) (*Client, error) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	current, err := registeredClient(clientID, registrationAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	config.ID = clientID
+	client, err := s.newRegisteredClient(config, current.secret)
+	if err != nil {
+		return nil, err
+	}
+	client.registrationAccessToken = current.registrationAccessToken
+	clients[client.id] = client
+
+	return client, nil
+}
+
+// DeleteClient removes a dynamically registered client along with its
+// pending auth requests and issued tokens (RFC 7592).
+func (s *Storage[T]) DeleteClient(ctx context.Context, clientID string) error {
+	clientsLock.Lock()
+	client, ok := clients[clientID]
+	if !ok || client.registrationAccessToken == "" {
+		clientsLock.Unlock()
+		return errors.New("client not found")
+	}
+	delete(clients, clientID)
+	clientsLock.Unlock()
+
+	_, err := s.RevokeEntries(ctx, EntryFilter{ClientID: clientID})
+	return err
+}
+
+// newRegisteredClient creates a client for dynamic registration, keeping the given secret
+// or generating one if the auth method requires it.
+func (s *Storage[T]) newRegisteredClient(config ClientConfig, secret string) (*Client, error) {
+	config.Secret = ""
+	if config.AuthMethod != oidc.AuthMethodNone && config.AuthMethod != oidc.AuthMethodPrivateKeyJWT {
+		if secret == "" {
+			var err error
+			if secret, err = randomSecret(); err != nil {
+				return nil, fmt.Errorf("could not generate client secret: %w", err)
+			}
+		}
+		config.Secret = secret
+	}
+	client, err := NewClient(config, s.pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+	// make sure the key is published before any token is signed with it
+	if _, err := s.keys.signingKey(client.idTokenSignedResponseAlg); err != nil {
+		return nil, err
+	}
+	return client, nil
 }
 
 // GetClientByClientID implements the op.Storage interface
 // it will be called whenever information (type, redirect_uris, ...) about the client behind the client_id is needed
 func (s *Storage[T]) GetClientByClientID(ctx context.Context, clientID string) (op.Client, error) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	client, ok := s.clients[clientID]
+	client, ok := getClient(clientID)
 	if !ok {
 		return nil, fmt.Errorf("client not found")
 	}
-	return RedirectGlobsClient(client), nil
+	// the server defaults apply where the client doesn't override them, e.g. for IDTokenLifetime
+	c := *client
+	c.tokenLifetimes = s.clientTokenLifetimes(clientID)
+	if c.accessTokenType == op.AccessTokenTypeJWT && issuedAccessTokenFromContext(ctx) != nil {
+		// JWT access tokens are created by JWTAccessToken instead of the library (see ContextWithJWTAccessTokens)
+		c.accessTokenType = op.AccessTokenTypeBearer
+	}
+	return RedirectGlobsClient(&c), nil
+}
+
+// clientTokenLifetimes returns the token lifetimes of a client, falling back to the server defaults.
+func (s *Storage[T]) clientTokenLifetimes(clientID string) TokenLifetimes {
+	if client, ok := getClient(clientID); ok {
+		return client.tokenLifetimes.withDefaults(s.tokenLifetimes)
+	}
+	return s.tokenLifetimes
+}
+
+// codeReplayWindow returns how long the used codes of a client are kept to detect their replay,
+// which is as long as the tokens issued from them may be valid.
+func (s *Storage[T]) codeReplayWindow(clientID string) time.Duration {
+	lifetimes := s.clientTokenLifetimes(clientID)
+	if lifetimes.RefreshToken > lifetimes.AccessToken {
+		return s.codeLifetime + lifetimes.RefreshToken
+	}
+	return s.codeLifetime + lifetimes.AccessToken
 }
 
 // AuthorizeClientIDSecret implements the op.Storage interface
 // it will be called for validating the client_id, client_secret on token or introspection requests
 func (s *Storage[T]) AuthorizeClientIDSecret(ctx context.Context, clientID, clientSecret string) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	client, ok := s.clients[clientID]
+	client, ok := getClient(clientID)
 	if !ok {
 		return fmt.Errorf("client not found")
 	}
@@ -471,20 +907,30 @@ func (s *Storage[T]) SetUserinfoFromScopes(ctx context.Context, userinfo *oidc.U
 // SetUserinfoFromRequests implements the op.CanSetUserinfoFromRequest interface.  In the
 // next major release, it will be required for op.Storage.
 // It will be called for the creation of an id_token, so we'll just pass it to the private function without any further check
+// and add the sid of the session the user signed in with. The library sets the acr of auth requests only,
+// so it is added for refresh token requests.
 func (s *Storage[T]) SetUserinfoFromRequest(ctx context.Context, userinfo *oidc.UserInfo, token op.IDTokenRequest, scopes []string) error {
-	return s.setUserinfo(ctx, userinfo, token.GetSubject(), token.GetClientID(), scopes)
+	if err := s.setUserinfo(ctx, userinfo, token.GetSubject(), token.GetClientID(), scopes); err != nil {
+		return err
+	}
+	if sessionID := sessionIDFromRequest(token); sessionID != "" {
+		userinfo.AppendClaims("sid", sessionID)
+	}
+	if refreshReq, ok := token.(*RefreshTokenRequest); ok && refreshReq.ACR != "" {
+		userinfo.AppendClaims("acr", refreshReq.ACR)
+	}
+	return nil
 }
 
 // SetUserinfoFromToken implements the op.Storage interface
 // it will be called for the userinfo endpoint, so we read the token and pass the information from that to the private function
 func (s *Storage[T]) SetUserinfoFromToken(ctx context.Context, userinfo *oidc.UserInfo, tokenID, subject, origin string) error {
-	token, ok := func() (*Token, bool) {
+	token, err := func() (*Token, error) {
 		s.lock.Lock()
 		defer s.lock.Unlock()
-		token, ok := s.tokens[tokenID]
-		return token, ok
+		return s.tokens.get(tokenID)
 	}()
-	if !ok {
+	if err != nil || token.expired(time.Now()) {
 		return fmt.Errorf("token is invalid or has expired")
 	}
 	// the userinfo endpoint should support CORS. If it's not possible to specify a specific origin in the CORS handler,
@@ -506,13 +952,12 @@ func (s *Storage[T]) SetUserinfoFromToken(ctx context.Context, userinfo *oidc.Us
 // SetIntrospectionFromToken implements the op.Storage interface
 // it will be called for the introspection endpoint, so we read the token and pass the information from that to the private function
 func (s *Storage[T]) SetIntrospectionFromToken(ctx context.Context, introspection *oidc.IntrospectionResponse, tokenID, subject, clientID string) error {
-	token, ok := func() (*Token, bool) {
+	token, err := func() (*Token, error) {
 		s.lock.Lock()
 		defer s.lock.Unlock()
-		token, ok := s.tokens[tokenID]
-		return token, ok
+		return s.tokens.get(tokenID)
 	}()
-	if !ok {
+	if err != nil || token.expired(time.Now()) {
 		return fmt.Errorf("token is invalid or has expired")
 	}
 	// check if the client is part of the requested audience
@@ -529,6 +974,10 @@ func (s *Storage[T]) SetIntrospectionFromToken(ctx context.Context, introspectio
 				return err
 			}
 			introspection.SetUserInfo(userInfo)
+			//...the acr the user achieved when signing in...
+			if token.ACR != "" {
+				introspection.Claims = AppendClaim(introspection.Claims, "acr", token.ACR)
+			}
 			//...and also the requested scopes...
 			introspection.Scope = token.Scopes
 			//...and the client the token was issued to
@@ -583,21 +1032,30 @@ func (s *Storage[T]) Health(ctx context.Context) error {
 }
 
 // createRefreshToken will store a refresh_token in-memory based on the provided information
-func (s *Storage[T]) createRefreshToken(accessToken *Token, amr []string, authTime time.Time) (string, error) {
+func (s *Storage[T]) createRefreshToken(accessToken *Token, amr []string, authTime time.Time, sessionID string) (string, error) {
+	lifetimes := s.clientTokenLifetimes(accessToken.ApplicationID)
+	now := time.Now()
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	token := &RefreshToken{
 		ID:            accessToken.RefreshTokenID,
 		Token:         accessToken.RefreshTokenID,
+		FamilyID:      accessToken.RefreshTokenID,
 		AuthTime:      authTime,
 		AMR:           amr,
+		ACR:           accessToken.ACR,
+		SessionID:     sessionID,
 		ApplicationID: accessToken.ApplicationID,
+		AuthRequestID: accessToken.AuthRequestID,
 		UserID:        accessToken.Subject,
 		Audience:      accessToken.Audience,
-		Expiration:    time.Now().Add(5 * time.Hour),
 		Scopes:        accessToken.Scopes,
 	}
-	s.refreshTokens[token.ID] = token
+	token.AbsoluteExpiration = now.Add(lifetimes.RefreshToken)
+	token.touch(now, lifetimes.RefreshTokenIdle)
+	if err := s.refreshTokens.put(token.ID, token); err != nil {
+		return "", err
+	}
 	return token.Token, nil
 }
 
@@ -605,40 +1063,92 @@ func (s *Storage[T]) createRefreshToken(accessToken *Token, amr []string, authTi
 func (s *Storage[T]) renewRefreshToken(currentRefreshToken string) (string, string, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	refreshToken, ok := s.refreshTokens[currentRefreshToken]
-	if !ok {
+	refreshToken, err := s.refreshTokens.get(currentRefreshToken)
+	if err != nil || refreshToken.expired(time.Now()) {
 		return "", "", fmt.Errorf("invalid refresh token")
 	}
-	// deletes the refresh token and all access tokens which were issued based on this refresh token
-	delete(s.refreshTokens, currentRefreshToken)
-	for _, token := range s.tokens {
-		if token.RefreshTokenID == currentRefreshToken {
-			delete(s.tokens, token.ID)
-			break
+	// the token may have been rotated by a concurrent request since TokenRequestByRefreshToken
+	if err := s.checkRefreshTokenReuse(refreshToken); err != nil {
+		return "", "", err
+	}
+	idleLifetime := s.clientTokenLifetimes(refreshToken.ApplicationID).RefreshTokenIdle
+
+	rotation := RefreshTokenRotate
+	if client, ok := getClient(refreshToken.ApplicationID); ok {
+		rotation = client.RefreshTokenRotation()
+	}
+	switch rotation {
+	case RefreshTokenReuseAllowed:
+		// the refresh token is kept, only its idle lifetime restarts
+		refreshToken.touch(time.Now(), idleLifetime)
+		if err := s.refreshTokens.put(currentRefreshToken, refreshToken); err != nil {
+			return "", "", err
+		}
+		return refreshToken.Token, refreshToken.ID, nil
+	case RefreshTokenReuseDetect:
+		// the rotated refresh token is kept to detect its reuse,
+		// but all access tokens which were issued based on it are deleted
+		if _, err := s.tokens.deleteWhere(func(_ string, token *Token) bool {
+			return token.RefreshTokenID == currentRefreshToken
+		}); err != nil {
+			return "", "", err
+		}
+		rotated := *refreshToken
+		rotated.Rotated = true
+		if err := s.refreshTokens.put(currentRefreshToken, &rotated); err != nil {
+			return "", "", err
+		}
+	default:
+		// deletes the refresh token and all access tokens which were issued based on this refresh token
+		if _, err := s.revokeRefreshToken(currentRefreshToken); err != nil {
+			return "", "", err
 		}
 	}
-	// creates a new refresh token based on the current one
+	// creates a new refresh token based on the current one, keeping its family and absolute expiration
 	token := uuid.NewString()
 	refreshToken.Token = token
 	refreshToken.ID = token
-	s.refreshTokens[token] = refreshToken
+	refreshToken.touch(time.Now(), idleLifetime)
+	if err := s.refreshTokens.put(token, refreshToken); err != nil {
+		return "", "", err
+	}
 	return token, refreshToken.ID, nil
 }
 
+// checkRefreshTokenReuse revokes the family of the refresh token if it has already been rotated.
+// s.lock must be held.
+func (s *Storage[T]) checkRefreshTokenReuse(refreshToken *RefreshToken) error {
+	if !refreshToken.Rotated {
+		return nil
+	}
+	revoked, err := s.revokeRefreshTokenFamily(refreshToken.FamilyID)
+	if err != nil {
+		return err
+	}
+	log.Printf("rotated refresh token reused: revoked %d tokens and %d refresh tokens of family %s",
+		revoked.Tokens, revoked.RefreshTokens, refreshToken.FamilyID)
+	return fmt.Errorf("refresh_token has already been used")
+}
+
 // accessToken will store an access_token in-memory based on the provided information
-func (s *Storage[T]) accessToken(applicationID, refreshTokenID, subject string, audience, scopes []string) (*Token, error) {
+func (s *Storage[T]) accessToken(applicationID, refreshTokenID, authRequestID, subject, acr string, audience, scopes []string) (*Token, error) {
+	lifetime := s.clientTokenLifetimes(applicationID).AccessToken
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	token := &Token{
 		ID:             uuid.NewString(),
 		ApplicationID:  applicationID,
 		RefreshTokenID: refreshTokenID,
+		AuthRequestID:  authRequestID,
 		Subject:        subject,
 		Audience:       audience,
-		Expiration:     time.Now().Add(5 * time.Minute),
+		Expiration:     time.Now().Add(lifetime),
 		Scopes:         scopes,
+		ACR:            acr,
+	}
+	if err := s.tokens.put(token.ID, token); err != nil {
+		return nil, err
 	}
-	s.tokens[token.ID] = token
 	return token, nil
 }
 
@@ -752,6 +1262,28 @@ func (s *Storage[T]) getTokenExchangeClaims(ctx context.Context, request op.Toke
 	return claims
 }
 
+// authRequestIDFromRequest returns the ID of the auth request tokens for the request originate from,
+// which is kept across refresh token requests.
+func authRequestIDFromRequest(req op.TokenRequest) string {
+	switch req := req.(type) {
+	case *AuthRequest:
+		return req.ID
+	case *RefreshTokenRequest:
+		return req.AuthRequestID
+	}
+	return ""
+}
+
+func sessionIDFromRequest(req op.TokenRequest) string {
+	switch req := req.(type) {
+	case *AuthRequest:
+		return req.SessionID
+	case *RefreshTokenRequest:
+		return req.SessionID
+	}
+	return ""
+}
+
 // getInfoFromRequest returns the clientID, authTime and amr depending on the op.TokenRequest type / implementation
 func getInfoFromRequest(req op.TokenRequest) (clientID string, authTime time.Time, amr []string) {
 	authReq, ok := req.(*AuthRequest) // Code Flow (with scope offline_access)
@@ -782,35 +1314,47 @@ func AppendClaim(claims map[string]interface{}, claim string, value interface{})
 }
 
 type deviceAuthorizationEntry struct {
-	deviceCode string
-	userCode   string
-	state      *op.DeviceAuthorizationState
+	DeviceCode string                       `json:"deviceCode"`
+	UserCode   string                       `json:"userCode"`
+	State      *op.DeviceAuthorizationState `json:"state"`
+}
+
+// deviceAuthorizationByUserCode returns the device authorization the user code was issued for.
+// s.lock must be held.
+func (s *Storage[T]) deviceAuthorizationByUserCode(userCode string) (*deviceAuthorizationEntry, error) {
+	deviceCode, err := s.userCodes.get(userCode)
+	if err != nil {
+		return nil, err
+	}
+	return s.deviceCodes.get(*deviceCode)
 }
 
 func (s *Storage[T]) StoreDeviceAuthorization(ctx context.Context, clientID, deviceCode, userCode string, expires time.Time, scopes []string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if _, ok := s.clients[clientID]; !ok {
+	if _, ok := getClient(clientID); !ok {
 		return errors.New("client not found")
 	}
 
-	if _, ok := s.userCodes[userCode]; ok {
+	if _, err := s.userCodes.get(userCode); err == nil {
 		return op.ErrDuplicateUserCode
 	}
 
-	s.deviceCodes[deviceCode] = deviceAuthorizationEntry{
-		deviceCode: deviceCode,
-		userCode:   userCode,
-		state: &op.DeviceAuthorizationState{
+	err := s.deviceCodes.put(deviceCode, &deviceAuthorizationEntry{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		State: &op.DeviceAuthorizationState{
 			ClientID: clientID,
 			Scopes:   scopes,
 			Expires:  expires,
 		},
+	})
+	if err != nil {
+		return err
 	}
 
-	s.userCodes[userCode] = deviceCode
-	return nil
+	return s.userCodes.put(userCode, &deviceCode)
 }
 
 func (s *Storage[T]) GetDeviceAuthorizatonState(ctx context.Context, clientID, deviceCode string) (*op.DeviceAuthorizationState, error) {
@@ -821,46 +1365,51 @@ func (s *Storage[T]) GetDeviceAuthorizatonState(ctx context.Context, clientID, d
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	entry, ok := s.deviceCodes[deviceCode]
-	if !ok || entry.state.ClientID != clientID {
+	entry, err := s.deviceCodes.get(deviceCode)
+	if err != nil || entry.State.ClientID != clientID {
 		return nil, errors.New("device code not found for client") // is there a standard not found error in the framework?
 	}
 
-	return entry.state, nil
+	return entry.State, nil
 }
 
 func (s *Storage[T]) GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*op.DeviceAuthorizationState, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	entry, ok := s.deviceCodes[s.userCodes[userCode]]
-	if !ok {
+	entry, err := s.deviceAuthorizationByUserCode(userCode)
+	if err != nil {
 		return nil, errors.New("user code not found")
 	}
 
-	return entry.state, nil
+	return entry.State, nil
 }
 
 func (s *Storage[T]) CompleteDeviceAuthorization(ctx context.Context, userCode, subject string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	entry, ok := s.deviceCodes[s.userCodes[userCode]]
-	if !ok {
+	entry, err := s.deviceAuthorizationByUserCode(userCode)
+	if err != nil {
 		return errors.New("user code not found")
 	}
 
-	entry.state.Subject = subject
-	entry.state.Done = true
-	return nil
+	entry.State.Subject = subject
+	entry.State.Done = true
+	return s.deviceCodes.put(entry.DeviceCode, entry)
 }
 
 func (s *Storage[T]) DenyDeviceAuthorization(ctx context.Context, userCode string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.deviceCodes[s.userCodes[userCode]].state.Denied = true
-	return nil
+	entry, err := s.deviceAuthorizationByUserCode(userCode)
+	if err != nil {
+		return errors.New("user code not found")
+	}
+
+	entry.State.Denied = true
+	return s.deviceCodes.put(entry.DeviceCode, entry)
 }
 
 // AuthRequestDone is used by testing and is not required to implement op.Storage
@@ -868,12 +1417,14 @@ func (s *Storage[T]) AuthRequestDone(id string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if req, ok := s.authRequests[id]; ok {
-		req.done = true
-		return nil
+	req, err := s.authRequests.get(id)
+	if err != nil {
+		return errors.New("request not found")
 	}
 
-	return errors.New("request not found")
+	amr := []string{"pwd"}
+	req.complete(req.UserID, time.Now(), amr, s.acrFor(req, req.UserID, amr))
+	return s.authRequests.put(id, req)
 }
 
 func (s *Storage[T]) ClientCredentials(ctx context.Context, clientID, clientSecret string) (op.Client, error) {
@@ -0,0 +1,522 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	testRedirectURI = "http://localhost:9999/auth/callback"
+	testACR         = "urn:example:loa:1"
+	testMFAACR      = "urn:example:loa:2"
+)
+
+type testUser struct {
+	ID_         string   `json:"id"`
+	Username_   string   `json:"username"`
+	Password_   string   `json:"password"`
+	TOTPSecret_ string   `json:"totpSecret,omitempty"`
+	ACRValues_  []string `json:"acrValues,omitempty"`
+}
+
+func (u testUser) ID() string          { return u.ID_ }
+func (u testUser) Username() string    { return u.Username_ }
+func (u testUser) Password() string    { return u.Password_ }
+func (u testUser) IsAdmin() bool       { return false }
+func (u testUser) TOTPSecret() string  { return u.TOTPSecret_ }
+func (u testUser) ACRValues() []string { return u.ACRValues_ }
+
+var testUsers = map[string]testUser{
+	"alice": {ID_: "alice-id", Username_: "alice", Password_: "alice", ACRValues_: []string{testACR, testMFAACR}},
+	// the secret of the RFC 6238 test vectors for HMAC-SHA1
+	"bob": {ID_: "bob-id", Username_: "bob", Password_: "bob", TOTPSecret_: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", ACRValues_: []string{testACR, testMFAACR}},
+}
+
+// newTestStorage creates a storage with testUsers, keeping state in memory.
+func newTestStorage(t *testing.T, opts ...Option) *Storage[testUser] {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := json.Marshal(testUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "users.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	userStore, err := NewUserStore[testUser]("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStorage(userStore,
+		func(user *testUser, userInfo *oidc.UserInfo, scope string, clientID string) {},
+		func(ctx context.Context, userID, clientID string, scopes []string) (map[string]interface{}, error) {
+			return nil, nil
+		},
+		opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// registerTestClient registers the client for the duration of the test.
+func registerTestClient(t *testing.T, client *Client) {
+	t.Helper()
+	RegisterClients(client)
+	t.Cleanup(func() {
+		clientsLock.Lock()
+		defer clientsLock.Unlock()
+		delete(clients, client.id)
+	})
+}
+
+// testAuthRequest returns a code flow request of the client for a refresh token.
+func testAuthRequest(clientID string) *oidc.AuthRequest {
+	return &oidc.AuthRequest{
+		ClientID:     clientID,
+		RedirectURI:  testRedirectURI,
+		Scopes:       oidc.SpaceDelimitedArray{oidc.ScopeOpenID, oidc.ScopeOfflineAccess},
+		ResponseType: oidc.ResponseTypeCode,
+	}
+}
+
+// signIn creates the auth request and completes it with the password of the user.
+func signIn(t *testing.T, s *Storage[testUser], ctx context.Context, req *oidc.AuthRequest, username string) *AuthRequest {
+	t.Helper()
+	authReq, err := s.CreateAuthRequest(ctx, req, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := testUsers[username]
+	if err := s.CheckUsernamePassword(user.Username_, user.Password_, authReq.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	request, err := s.authRequests.get(authReq.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+// startSession signs the user in for the client and starts a session, returning it and ctx
+// with the session as the browser's.
+func startSession(t *testing.T, s *Storage[testUser], ctx context.Context, clientID, username string) (*Session, context.Context) {
+	t.Helper()
+	request := signIn(t, s, ctx, testAuthRequest(clientID), username)
+	session, err := s.CreateSession(ctx, request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, ContextWithSessionIDs(ctx, []string{session.ID})
+}
+
+func TestRefreshToken(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "refresh-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	accessToken, refreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, signIn(t, s, ctx, testAuthRequest(clientID), "alice"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := s.TokenRequestByRefreshToken(ctx, refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewedAccessToken, renewed, _, err := s.CreateAccessAndRefreshTokens(ctx, req, refreshToken)
+	if err != nil {
+		t.Fatalf("refreshing: %v", err)
+	}
+	if renewed == refreshToken {
+		t.Error("refresh token was not renewed")
+	}
+	if _, err := s.tokens.get(accessToken); err == nil {
+		t.Error("access token of the used refresh token is still valid")
+	}
+	if err := s.SetUserinfoFromToken(ctx, new(oidc.UserInfo), renewedAccessToken, "alice-id", ""); err != nil {
+		t.Errorf("renewed access token was rejected: %v", err)
+	}
+	if _, err := s.TokenRequestByRefreshToken(ctx, renewed); err != nil {
+		t.Errorf("renewed refresh token was rejected: %v", err)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	tests := []struct {
+		rotation RefreshTokenRotation
+		// wantSame is set if the refresh token is kept when used
+		wantSame bool
+		// wantReuseErr is set if using the first refresh token again fails
+		wantReuseErr bool
+		// wantRenewedRevoked is set if reusing the first refresh token revokes the renewed one
+		wantRenewedRevoked bool
+	}{
+		{rotation: RefreshTokenRotate, wantReuseErr: true},
+		{rotation: RefreshTokenReuseAllowed, wantSame: true},
+		{rotation: RefreshTokenReuseDetect, wantReuseErr: true, wantRenewedRevoked: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.rotation), func(t *testing.T) {
+			s := newTestStorage(t)
+			clientID := "refresh-" + string(tt.rotation)
+			registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI).WithRefreshTokenRotation(tt.rotation))
+			ctx := ContextWithClientID(context.Background(), clientID)
+
+			refresh := func(refreshToken string) (string, string, error) {
+				req, err := s.TokenRequestByRefreshToken(ctx, refreshToken)
+				if err != nil {
+					return "", "", err
+				}
+				accessToken, newRefreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, req, refreshToken)
+				return accessToken, newRefreshToken, err
+			}
+			accessTokenValid := func(tokenID string) bool {
+				_, err := s.tokens.get(tokenID)
+				return err == nil
+			}
+
+			first, refreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, signIn(t, s, ctx, testAuthRequest(clientID), "alice"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			renewedAccessToken, renewed, err := refresh(refreshToken)
+			if err != nil {
+				t.Fatalf("refreshing: %v", err)
+			}
+			if (renewed == refreshToken) != tt.wantSame {
+				t.Errorf("refresh token kept = %v, want %v", renewed == refreshToken, tt.wantSame)
+			}
+			if accessTokenValid(first) == !tt.wantSame {
+				t.Errorf("access token of the used refresh token valid = %v, want %v", !tt.wantSame, tt.wantSame)
+			}
+
+			if _, _, err := refresh(refreshToken); (err != nil) != tt.wantReuseErr {
+				t.Errorf("reusing refresh token error = %v, wantErr %v", err, tt.wantReuseErr)
+			}
+			if _, _, err := refresh(renewed); (err != nil) != tt.wantRenewedRevoked {
+				t.Errorf("using renewed refresh token error = %v, wantErr %v", err, tt.wantRenewedRevoked)
+			}
+			if tt.wantRenewedRevoked && accessTokenValid(renewedAccessToken) {
+				t.Error("access token of the revoked family is still valid")
+			}
+		})
+	}
+}
+
+func TestExpiredTokens(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "refresh-expired"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI).WithTokenLifetimes(TokenLifetimes{
+		AccessToken:  time.Millisecond,
+		RefreshToken: time.Millisecond,
+	}))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	request := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+	accessToken, refreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, request, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.TokenRequestByRefreshToken(ctx, refreshToken); err == nil {
+		t.Error("expired refresh token was accepted")
+	}
+	if _, _, _, err := s.CreateAccessAndRefreshTokens(ctx, request, refreshToken); err == nil {
+		t.Error("expired refresh token was renewed")
+	}
+	if err := s.SetUserinfoFromToken(ctx, new(oidc.UserInfo), accessToken, "alice-id", ""); err == nil {
+		t.Error("expired access token was accepted at userinfo")
+	}
+	if err := s.SetIntrospectionFromToken(ctx, new(oidc.IntrospectionResponse), accessToken, "alice-id", clientID); err == nil {
+		t.Error("expired access token was introspected as active")
+	}
+}
+
+func TestAuthRequestByCode(t *testing.T) {
+	const (
+		clientID = "code-client"
+		verifier = "a-code-verifier-of-at-least-forty-three-characters"
+	)
+	tests := []struct {
+		name string
+		pkce bool
+		// clientID and verifier are presented first
+		clientID string
+		verifier string
+		wantErr  bool
+	}{
+		{name: "valid", clientID: clientID},
+		{name: "valid with PKCE", pkce: true, clientID: clientID, verifier: verifier},
+		{name: "other client", clientID: "other-client", wantErr: true},
+		{name: "missing client", wantErr: true},
+		{name: "wrong code_verifier", pkce: true, clientID: clientID, verifier: "wrong", wantErr: true},
+		{name: "missing code_verifier", pkce: true, clientID: clientID, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+			ctx := ContextWithClientID(context.Background(), clientID)
+
+			req := testAuthRequest(clientID)
+			if tt.pkce {
+				req.CodeChallenge = oidc.NewSHACodeChallenge(verifier)
+				req.CodeChallengeMethod = oidc.CodeChallengeMethodS256
+			}
+			request := signIn(t, s, ctx, req, "alice")
+			const code = "the-code"
+			if err := s.SaveAuthCode(ctx, request.GetID(), code); err != nil {
+				t.Fatal(err)
+			}
+			exchange := func(clientID, verifier string) (op.AuthRequest, error) {
+				return s.AuthRequestByCode(ContextWithCodeVerifier(ContextWithClientID(ctx, clientID), verifier), code)
+			}
+			legitimateVerifier := ""
+			if tt.pkce {
+				legitimateVerifier = verifier
+			}
+
+			_, err := exchange(tt.clientID, tt.verifier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthRequestByCode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// the code must not be burnt by a rejected presentation
+				if _, err := exchange(clientID, legitimateVerifier); err != nil {
+					t.Fatalf("exchange after rejected presentation: %v", err)
+				}
+			}
+
+			accessToken, refreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, request, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = exchange(clientID, legitimateVerifier)
+			if err == nil || !strings.Contains(err.Error(), "already used") {
+				t.Fatalf("replayed code error = %v, want already used", err)
+			}
+			if _, err := s.tokens.get(accessToken); err == nil {
+				t.Error("access token issued from replayed code is still valid")
+			}
+			if _, err := s.TokenRequestByRefreshToken(ctx, refreshToken); err == nil {
+				t.Error("refresh token issued from replayed code is still valid")
+			}
+		})
+	}
+}
+
+func TestExpiredAuthCode(t *testing.T) {
+	s := newTestStorage(t, WithCodeLifetime(time.Millisecond))
+	clientID := "code-expired"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	request := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+	if err := s.SaveAuthCode(ctx, request.GetID(), "the-code"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.AuthRequestByCode(ctx, "the-code"); err == nil {
+		t.Error("expired code was accepted")
+	}
+}
+
+func TestReplayedExpiredAuthCode(t *testing.T) {
+	s := newTestStorage(t, WithCodeLifetime(time.Millisecond))
+	clientID := "code-replayed-expired"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	request := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+	if err := s.SaveAuthCode(ctx, request.GetID(), "the-code"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthRequestByCode(ctx, "the-code"); err != nil {
+		t.Fatal(err)
+	}
+	accessToken, refreshToken, _, err := s.CreateAccessAndRefreshTokens(ctx, request, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	s.purgeExpired(time.Now())
+
+	_, err = s.AuthRequestByCode(ctx, "the-code")
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("replayed expired code error = %v, want already used", err)
+	}
+	if _, err := s.tokens.get(accessToken); err == nil {
+		t.Error("access token issued from replayed code is still valid")
+	}
+	if _, err := s.TokenRequestByRefreshToken(ctx, refreshToken); err == nil {
+		t.Error("refresh token issued from replayed code is still valid")
+	}
+}
+
+func TestJWTAccessToken(t *testing.T) {
+	const (
+		issuer   = "http://localhost:10001"
+		clientID = "jwt-client"
+	)
+	s := newTestStorage(t)
+	s.getPrivateClaimsFromScopes = func(ctx context.Context, userID, clientID string, scopes []string) (map[string]interface{}, error) {
+		return map[string]interface{}{"custom": userID}, nil
+	}
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI).WithAccessTokenType(op.AccessTokenTypeJWT))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	client, err := s.GetClientByClientID(ctx, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.AccessTokenType() != op.AccessTokenTypeJWT {
+		t.Errorf("access token type = %v, want JWT", client.AccessTokenType())
+	}
+	ctx = op.ContextWithIssuer(ContextWithJWTAccessTokens(ctx), issuer)
+	if client, err = s.GetClientByClientID(ctx, clientID); err != nil {
+		t.Fatal(err)
+	}
+	if client.AccessTokenType() != op.AccessTokenTypeBearer {
+		t.Errorf("access token type reported while issuing = %v, want bearer", client.AccessTokenType())
+	}
+
+	request := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+	request.acr = testACR
+	tokenID, _, err := s.CreateAccessToken(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, ok, err := s.JWTAccessToken(ctx, issuer)
+	if err != nil || !ok {
+		t.Fatalf("JWTAccessToken() = %v, %v", ok, err)
+	}
+	if _, ok, _ := s.JWTAccessToken(ctx, issuer); ok {
+		t.Error("access token was returned twice")
+	}
+
+	jws, err := jose.ParseSigned(accessToken)
+	if err != nil {
+		t.Fatalf("parsing access token: %v", err)
+	}
+	header := jws.Signatures[0].Header
+	if typ := header.ExtraHeaders[jose.HeaderType]; typ != jwtAccessTokenType {
+		t.Errorf("typ = %v, want %s", typ, jwtAccessTokenType)
+	}
+	key := publicKeyByID(s.keys.publicKeys(), header.KeyID)
+	if key == nil {
+		t.Fatalf("access token signed with unpublished key %s", header.KeyID)
+	}
+	payload, err := jws.Verify(key.Key())
+	if err != nil {
+		t.Fatalf("verifying access token: %v", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"iss":       issuer,
+		"sub":       "alice-id",
+		"client_id": clientID,
+		"scope":     oidc.ScopeOpenID + " " + oidc.ScopeOfflineAccess,
+		"acr":       testACR,
+		"jti":       tokenID,
+		"custom":    "alice-id",
+	}
+	for claim, value := range want {
+		if claims[claim] != value {
+			t.Errorf("%s = %v, want %v", claim, claims[claim], value)
+		}
+	}
+}
+
+func TestBearerAccessToken(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "bearer-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithJWTAccessTokens(ContextWithClientID(context.Background(), clientID))
+
+	if _, _, err := s.CreateAccessToken(ctx, signIn(t, s, ctx, testAuthRequest(clientID), "alice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.JWTAccessToken(ctx, "http://localhost:10001"); ok || err != nil {
+		t.Errorf("JWTAccessToken() = %v, %v, want bearer token", ok, err)
+	}
+}
+
+func TestCreateAuthRequestPrompt(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "prompt-client"
+	consentClientID := "prompt-consent-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	registerTestClient(t, WebClient(consentClientID, "secret", "", testRedirectURI).WithConsentRequired(true))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	session, withSession := startSession(t, s, ctx, clientID, "alice")
+	// sign in an hour ago, for max_age
+	session.AuthTime = time.Now().Add(-time.Hour)
+	if err := s.sessions.put(session.ID, session); err != nil {
+		t.Fatal(err)
+	}
+	maxAge := func(seconds uint) *uint { return &seconds }
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		clientID    string
+		prompt      oidc.SpaceDelimitedArray
+		maxAge      *uint
+		wantErr     string
+		wantDone    bool
+		wantConsent bool
+	}{
+		{name: "no session", ctx: ctx, clientID: clientID},
+		{name: "session", ctx: withSession, clientID: clientID, wantDone: true},
+		{name: "prompt=none without session", ctx: ctx, clientID: clientID, prompt: oidc.SpaceDelimitedArray{oidc.PromptNone}, wantErr: "login_required"},
+		{name: "prompt=none with session", ctx: withSession, clientID: clientID, prompt: oidc.SpaceDelimitedArray{oidc.PromptNone}, wantDone: true},
+		{name: "prompt=none requiring consent", ctx: withSession, clientID: consentClientID, prompt: oidc.SpaceDelimitedArray{oidc.PromptNone}, wantErr: "consent_required"},
+		{name: "session requiring consent", ctx: withSession, clientID: consentClientID, wantConsent: true},
+		{name: "prompt=login with session", ctx: withSession, clientID: clientID, prompt: oidc.SpaceDelimitedArray{oidc.PromptLogin}},
+		{name: "max_age older than session", ctx: withSession, clientID: clientID, maxAge: maxAge(7200), wantDone: true},
+		{name: "max_age newer than session", ctx: withSession, clientID: clientID, maxAge: maxAge(60)},
+		{name: "prompt=none with max_age newer than session", ctx: withSession, clientID: clientID, prompt: oidc.SpaceDelimitedArray{oidc.PromptNone}, maxAge: maxAge(60), wantErr: "login_required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testAuthRequest(tt.clientID)
+			req.Prompt = tt.prompt
+			req.MaxAge = tt.maxAge
+			authReq, err := s.CreateAuthRequest(tt.ctx, req, "")
+			if tt.wantErr != "" {
+				var oidcErr *oidc.Error
+				if !errors.As(err, &oidcErr) || string(oidcErr.ErrorType) != tt.wantErr {
+					t.Fatalf("CreateAuthRequest() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := authReq.(*AuthRequest)
+			if request.Done() != tt.wantDone || request.ConsentPending() != tt.wantConsent {
+				t.Errorf("Done() = %v, ConsentPending() = %v, want %v, %v", request.Done(), request.ConsentPending(), tt.wantDone, tt.wantConsent)
+			}
+			if (tt.wantDone || tt.wantConsent) && (request.GetSubject() != session.UserID || request.SessionID != session.ID) {
+				t.Errorf("subject = %q, sid = %q, want %q, %q", request.GetSubject(), request.SessionID, session.UserID, session.ID)
+			}
+		})
+	}
+}
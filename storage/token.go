@@ -1,25 +1,145 @@
 package storage
 
-import "time"
+import (
+	"time"
 
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+)
+
+// Token is an access token.
+// AuthRequestID is set if it originates from an authorization code,
+// including tokens issued by refresh token requests.
 type Token struct {
-	ID             string
-	ApplicationID  string
-	Subject        string
-	RefreshTokenID string
-	Audience       []string
-	Expiration     time.Time
-	Scopes         []string
+	ID             string    `json:"id"`
+	ApplicationID  string    `json:"applicationID"`
+	Subject        string    `json:"subject"`
+	RefreshTokenID string    `json:"refreshTokenID"`
+	AuthRequestID  string    `json:"authRequestID"`
+	Audience       []string  `json:"audience"`
+	Expiration     time.Time `json:"expiration"`
+	Scopes         []string  `json:"scopes"`
+	ACR            string    `json:"acr"`
+}
+
+// expired reports whether the token is expired at the given time.
+func (t *Token) expired(now time.Time) bool {
+	return now.After(t.Expiration)
 }
 
+// RefreshToken is a refresh token.
+// Expiration is the end of its idle lifetime, if any, bounded by AbsoluteExpiration,
+// which is kept when the refresh token is renewed.
+// Refresh tokens renewed from the same one share the FamilyID of the first.
+// Rotated is set on renewed refresh tokens which are kept to detect their reuse.
 type RefreshToken struct {
-	ID            string
-	Token         string
-	AuthTime      time.Time
-	AMR           []string
-	Audience      []string
-	UserID        string
-	ApplicationID string
-	Expiration    time.Time
-	Scopes        []string
+	ID                 string    `json:"id"`
+	Token              string    `json:"token"`
+	FamilyID           string    `json:"familyID"`
+	Rotated            bool      `json:"rotated"`
+	AuthTime           time.Time `json:"authTime"`
+	AMR                []string  `json:"amr"`
+	ACR                string    `json:"acr"`
+	SessionID          string    `json:"sessionID"`
+	Audience           []string  `json:"audience"`
+	UserID             string    `json:"userID"`
+	ApplicationID      string    `json:"applicationID"`
+	AuthRequestID      string    `json:"authRequestID"`
+	Expiration         time.Time `json:"expiration"`
+	AbsoluteExpiration time.Time `json:"absoluteExpiration"`
+	Scopes             []string  `json:"scopes"`
+}
+
+// expired reports whether the refresh token is expired at the given time.
+func (t *RefreshToken) expired(now time.Time) bool {
+	return now.After(t.Expiration)
+}
+
+// touch restarts the idle lifetime of the refresh token at the given time.
+func (t *RefreshToken) touch(now time.Time, idleLifetime time.Duration) {
+	t.Expiration = t.AbsoluteExpiration
+	if idleLifetime > 0 && now.Add(idleLifetime).Before(t.AbsoluteExpiration) {
+		t.Expiration = now.Add(idleLifetime)
+	}
+}
+
+// RefreshTokenRotation defines what happens to a refresh token when it is used.
+type RefreshTokenRotation string
+
+const (
+	// RefreshTokenRotate replaces the refresh token with a new one on every use.
+	RefreshTokenRotate RefreshTokenRotation = "rotate"
+	// RefreshTokenReuseAllowed keeps the refresh token, so that it can be used repeatedly.
+	RefreshTokenReuseAllowed RefreshTokenRotation = "reuse-allowed"
+	// RefreshTokenReuseDetect rotates the refresh token and revokes its whole family,
+	// including access tokens, if an already rotated one is used again (OAuth 2.1 section 6.1).
+	RefreshTokenReuseDetect RefreshTokenRotation = "reuse-detect"
+)
+
+func (r RefreshTokenRotation) valid() bool {
+	switch r {
+	case RefreshTokenRotate, RefreshTokenReuseAllowed, RefreshTokenReuseDetect:
+		return true
+	}
+	return false
+}
+
+// TokenLifetimes are the lifetimes of issued tokens.
+type TokenLifetimes struct {
+	AccessToken time.Duration
+	// RefreshToken is the absolute lifetime of a refresh token, counted from its first issuance.
+	RefreshToken time.Duration
+	// RefreshTokenIdle is how long a refresh token may go unused before it expires.
+	// Disabled if zero.
+	RefreshTokenIdle time.Duration
+	IDToken          time.Duration
+}
+
+var defaultTokenLifetimes = TokenLifetimes{
+	AccessToken:  5 * time.Minute,
+	RefreshToken: 5 * time.Hour,
+	IDToken:      time.Hour,
+}
+
+// withDefaults returns the lifetimes with zero values replaced by those of defaults.
+func (l TokenLifetimes) withDefaults(defaults TokenLifetimes) TokenLifetimes {
+	if l.AccessToken == 0 {
+		l.AccessToken = defaults.AccessToken
+	}
+	if l.RefreshToken == 0 {
+		l.RefreshToken = defaults.RefreshToken
+	}
+	if l.RefreshTokenIdle == 0 {
+		l.RefreshTokenIdle = defaults.RefreshTokenIdle
+	}
+	if l.IDToken == 0 {
+		l.IDToken = defaults.IDToken
+	}
+	return l
+}
+
+// authCode is an authorization code issued for an auth request.
+// The client and code challenge of the request are kept, since the request is deleted
+// once the code is exchanged, but replays have to be checked against them.
+type authCode struct {
+	RequestID     string             `json:"requestID"`
+	ClientID      string             `json:"clientID"`
+	CodeChallenge *OIDCCodeChallenge `json:"codeChallenge"`
+	IssuedAt      time.Time          `json:"issuedAt"`
+	Used          bool               `json:"used"`
+}
+
+// presentedBy reports whether the code is exchanged by the client it was issued to with the verifier
+// of its code challenge, if any. Codes presented by anyone else must be rejected before they are
+// marked as used or their replay is detected, so that they can't be burnt with intercepted codes.
+func (c *authCode) presentedBy(clientID, codeVerifier string) bool {
+	if c.ClientID != clientID {
+		return false
+	}
+	challenge := CodeChallengeToOIDC(c.CodeChallenge)
+	return challenge == nil || challenge.Challenge == "" || oidc.VerifyCodeChallenge(challenge, codeVerifier)
+}
+
+// expired reports whether the code is expired at the given time.
+func (c *authCode) expired(now time.Time, lifetime time.Duration) bool {
+	return now.After(c.IssuedAt.Add(lifetime))
 }
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// totpPeriod and totpDigits are the defaults of RFC 6238, which authenticator apps assume.
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// totpSkew is the number of periods before and after the current one whose codes are accepted,
+	// allowing for clock drift and slow typing.
+	totpSkew = 1
+)
+
+// amrMFA are the authentication methods of users who signed in with password and TOTP code.
+var amrMFA = []string{"pwd", "otp", "mfa"}
+
+// UserWithTOTP is implemented by users who may have a TOTP secret, in which case they have to enter
+// a code of their authenticator app after their password.
+type UserWithTOTP interface {
+	User
+	// TOTPSecret is the base32 encoded secret, as in otpauth:// URIs. TOTP is disabled if empty.
+	TOTPSecret() string
+}
+
+// userTOTPSecret returns the TOTP secret of the user, if any.
+func userTOTPSecret[T User](user *T) string {
+	if user == nil {
+		return ""
+	}
+	withTOTP, ok := interface{}(*user).(UserWithTOTP)
+	if !ok {
+		return ""
+	}
+	return withTOTP.TOTPSecret()
+}
+
+// CheckTOTPCode completes the auth request of a user who entered the password (see CheckUsernamePassword)
+// if code is the current TOTP code of the user.
+func (s *Storage[T]) CheckTOTPCode(code, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, err := s.authRequests.get(id)
+	if err != nil {
+		return errors.New("request not found")
+	}
+	if !request.OTPPending() {
+		return errors.New("request is not pending a TOTP code")
+	}
+
+	now := time.Now()
+	valid, err := validTOTPCode(userTOTPSecret(s.userStore.GetUserByID(request.UserID)), strings.TrimSpace(code), now)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid code")
+	}
+	if err := s.completeAuthRequest(request, request.UserID, now, amrMFA, s.acrFor(request, request.UserID, amrMFA)); err != nil {
+		return err
+	}
+	return s.authRequests.put(id, request)
+}
+
+// CurrentTOTPCode returns the current TOTP code of the user of an auth request pending one,
+// so that it can be shown during development instead of being read from an authenticator app.
+func (s *Storage[T]) CurrentTOTPCode(ctx context.Context, id string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, err := s.authRequests.get(id)
+	if err != nil {
+		return "", errors.New("request not found")
+	}
+	if !request.OTPPending() {
+		return "", errors.New("request is not pending a TOTP code")
+	}
+	return TOTPCode(userTOTPSecret(s.userStore.GetUserByID(request.UserID)), time.Now())
+}
+
+// TOTPCode returns the code of the base32 encoded secret at time t, following RFC 6238
+// with HMAC-SHA1, 30 second periods and 6 digits.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/int64(totpPeriod/time.Second))), nil
+}
+
+// validTOTPCode reports whether code is the code of the secret at time t or a period within totpSkew.
+func validTOTPCode(secret, code string, t time.Time) (bool, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return false, err
+	}
+	counter := t.Unix() / int64(totpPeriod/time.Second)
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if hmac.Equal([]byte(hotp(key, uint64(counter+i))), []byte(code)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// decodeTOTPSecret decodes base32 secrets regardless of case, spaces and padding.
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, errors.New("invalid TOTP secret: empty")
+	}
+	return key, nil
+}
+
+// hotp computes the code for the counter as defined in RFC 4226 section 5.3.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the base32 encoding of the HMAC-SHA1 seed of the RFC 6238 test vectors, "12345678901234567890".
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("TOTPCode() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidTOTPCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name    string
+		secret  string
+		code    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "current", secret: rfc6238Secret, code: "050471", want: true},
+		{name: "lowercase secret with spaces", secret: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", code: "050471", want: true},
+		{name: "previous period", secret: rfc6238Secret, code: totpCodeAt(t, now.Add(-totpPeriod)), want: true},
+		{name: "next period", secret: rfc6238Secret, code: totpCodeAt(t, now.Add(totpPeriod)), want: true},
+		{name: "outside skew", secret: rfc6238Secret, code: totpCodeAt(t, now.Add(-2*totpPeriod))},
+		{name: "wrong", secret: rfc6238Secret, code: "000000"},
+		{name: "empty code", secret: rfc6238Secret, code: ""},
+		{name: "invalid secret", secret: "not base32!", code: "050471", wantErr: true},
+		{name: "empty secret", secret: "", code: "050471", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validTOTPCode(tt.secret, tt.code, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validTOTPCode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("validTOTPCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTOTPCode(t *testing.T) {
+	s := newTestStorage(t)
+	clientID := "totp-client"
+	registerTestClient(t, WebClient(clientID, "secret", "", testRedirectURI))
+	ctx := ContextWithClientID(context.Background(), clientID)
+
+	request := signIn(t, s, ctx, testAuthRequest(clientID), "bob")
+	if !request.OTPPending() || request.Done() {
+		t.Fatalf("after password: OTPPending() = %v, Done() = %v, want pending", request.OTPPending(), request.Done())
+	}
+
+	if err := s.CheckTOTPCode("000000", request.GetID()); err == nil {
+		t.Fatal("wrong code was accepted")
+	}
+	code, err := s.CurrentTOTPCode(ctx, request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckTOTPCode(" "+code+" ", request.GetID()); err != nil {
+		t.Fatalf("CheckTOTPCode() error = %v", err)
+	}
+
+	request, err = s.authRequests.get(request.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !request.Done() || request.OTPPending() {
+		t.Errorf("after code: OTPPending() = %v, Done() = %v, want done", request.OTPPending(), request.Done())
+	}
+	if !equalStrings(request.GetAMR(), amrMFA) {
+		t.Errorf("amr = %v, want %v", request.GetAMR(), amrMFA)
+	}
+	if err := s.CheckTOTPCode(code, request.GetID()); err == nil {
+		t.Error("code was accepted for a completed request")
+	}
+
+	withoutTOTP := signIn(t, s, ctx, testAuthRequest(clientID), "alice")
+	if withoutTOTP.OTPPending() || !withoutTOTP.Done() {
+		t.Errorf("user without secret: OTPPending() = %v, Done() = %v, want done", withoutTOTP.OTPPending(), withoutTOTP.Done())
+	}
+}
+
+func totpCodeAt(t *testing.T, at time.Time) string {
+	t.Helper()
+	code, err := TOTPCode(rfc6238Secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
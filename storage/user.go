@@ -5,15 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
-	"time"
-
-	"github.com/fsnotify/fsnotify"
 )
 
 type User interface {
@@ -23,6 +20,43 @@ type User interface {
 	IsAdmin() bool
 }
 
+// UserWithEmail is implemented by users with an email address, so that they can be found by it,
+// e.g. via login_hint.
+type UserWithEmail interface {
+	User
+	GetEmail() string
+}
+
+// FindUserByLoginHint returns the key in users of the user whose ID, username or email (see UserWithEmail)
+// matches the login_hint, in that order of precedence. Usernames and emails match case-insensitively.
+func FindUserByLoginHint[T User](users map[string]*T, hint string) (string, *T) {
+	if hint == "" {
+		return "", nil
+	}
+	keys := make([]string, 0, len(users))
+	for key := range users {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	matchers := []func(user T) bool{
+		func(user T) bool { return user.ID() == hint },
+		func(user T) bool { return strings.EqualFold(user.Username(), hint) },
+		func(user T) bool {
+			withEmail, ok := interface{}(user).(UserWithEmail)
+			return ok && withEmail.GetEmail() != "" && strings.EqualFold(withEmail.GetEmail(), hint)
+		},
+	}
+	for _, matches := range matchers {
+		for _, key := range keys {
+			if user := users[key]; user != nil && matches(*user) {
+				return key, user
+			}
+		}
+	}
+	return "", nil
+}
+
 type Service struct {
 	keys map[string]*rsa.PublicKey
 }
@@ -34,15 +68,67 @@ type UserStore[T User] interface {
 	Users() map[string]*T
 }
 
+// MutableUserStore is a UserStore whose users can be managed, e.g. through the admin API.
+// The store created by NewUserStore implements it.
+type MutableUserStore[T User] interface {
+	UserStore[T]
+	// CreateUser adds a new user, which is written to the users file for new users.
+	CreateUser(user *T) error
+	// UpdateUser replaces the user with the same ID in the file it was loaded from.
+	UpdateUser(user *T) error
+	// DeleteUser removes the user from the file it was loaded from.
+	DeleteUser(id string) error
+}
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user already exists")
+	ErrInvalidUser  = errors.New("invalid user")
+)
+
+// newUsersFile is the file in the users folder users created at runtime are written to.
+const newUsersFile = "users.json"
+
+// userSource is the location of a user in the users folder.
+type userSource struct {
+	filePath string
+	key      string
+}
+
 type userStore[T User] struct {
 	users   map[string]*T
+	sources map[string]userSource
 	dataDir string
 	mu      sync.RWMutex
 }
 
+// StorageErrors holds errors found when reloading watched data folders, e.g. users.
 var StorageErrors struct {
-	Errors []string
-	mu     sync.RWMutex
+	Errors   []string
+	mu       sync.RWMutex
+	bySource map[string][]string
+}
+
+// setStorageErrors replaces the errors for the given source, e.g. users.
+func setStorageErrors(source string, errs ...string) {
+	StorageErrors.mu.Lock()
+	defer StorageErrors.mu.Unlock()
+
+	if StorageErrors.bySource == nil {
+		StorageErrors.bySource = make(map[string][]string)
+	}
+	StorageErrors.bySource[source] = errs
+
+	sources := make([]string, 0, len(StorageErrors.bySource))
+	for source := range StorageErrors.bySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	StorageErrors.Errors = []string{}
+	for _, source := range sources {
+		StorageErrors.Errors = append(StorageErrors.Errors, StorageErrors.bySource[source]...)
+	}
 }
 
 func NewUserStore[T User](issuer string, dataDir string) (UserStore[T], error) {
@@ -56,7 +142,7 @@ func NewUserStore[T User](issuer string, dataDir string) (UserStore[T], error) {
 		return nil, fmt.Errorf("could not load users from JSON: %w", err)
 	}
 
-	go watchUsersFolder(dataDir, &store)
+	go watchFolder(dataDir, "users", store.LoadUsersFromJSON)
 
 	return &store, nil
 }
@@ -66,7 +152,15 @@ func (u *userStore[T]) ExampleClientID() string {
 }
 
 func (u *userStore[T]) Users() map[string]*T {
-	return u.users
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	users := make(map[string]*T, len(u.users))
+	for id, user := range u.users {
+		users[id] = user
+	}
+
+	return users
 }
 
 func (u *userStore[T]) LoadUsersFromJSON() error {
@@ -74,6 +168,7 @@ func (u *userStore[T]) LoadUsersFromJSON() error {
 	defer u.mu.Unlock()
 
 	u.users = make(map[string]*T)
+	u.sources = make(map[string]userSource)
 
 	files, err := os.ReadDir(u.dataDir)
 	if err != nil {
@@ -113,6 +208,7 @@ func (u *userStore[T]) LoadUsersFromJSON() error {
 					log.Println(errMsg)
 				}
 				u.users[(*user).ID()] = user
+				u.sources[(*user).ID()] = userSource{filePath: filePath, key: key}
 			}
 
 			if len(errs) > 0 {
@@ -146,79 +242,128 @@ func (u *userStore[T]) GetUserByUsername(username string) *T {
 	return nil
 }
 
-func watchUsersFolder[T User](dataDir string, userStore *userStore[T]) {
-	watcher, err := fsnotify.NewWatcher()
+func (u *userStore[T]) CreateUser(user *T) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.validateUser(user); err != nil {
+		return err
+	}
+	if _, exists := u.users[(*user).ID()]; exists {
+		return fmt.Errorf("%w: user with ID %s already exists", ErrUserExists, (*user).ID())
+	}
+
+	source := userSource{filePath: filepath.Join(u.dataDir, newUsersFile), key: (*user).ID()}
+	// the key may be taken by a user whose ID differs from it, which must not be overwritten
+	users, err := readUsersFile(source.filePath)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if _, exists := users[source.key]; exists {
+		return fmt.Errorf("%w: key %s already exists in %s", ErrUserExists, source.key, source.filePath)
 	}
-	defer watcher.Close()
+	if err := writeUser(source, user); err != nil {
+		return err
+	}
+	u.users[(*user).ID()] = user
+	u.sources[(*user).ID()] = source
 
-	done := make(chan bool)
-	debounceTimer := time.NewTimer(0)  // Create a timer with no initial delay
-	debouncedEvent := fsnotify.Event{} // Stores the latest event to process
+	return nil
+}
 
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Has(fsnotify.Write) {
-					log.Printf("file modified: %s", event.Name)
+func (u *userStore[T]) UpdateUser(user *T) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
 
-					debouncedEvent = event // update the debounced event always to latest
+	source, ok := u.sources[(*user).ID()]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := u.validateUser(user); err != nil {
+		return err
+	}
 
-					if !debounceTimer.Stop() {
-						// timer has already expired, drain the channel
-						select {
-						case <-debounceTimer.C:
-						default:
-						}
-					}
+	if err := writeUser(source, user); err != nil {
+		return err
+	}
+	u.users[(*user).ID()] = user
 
-					debounceTimer.Reset(50 * time.Millisecond)
-				}
-			case <-debounceTimer.C:
-				if debouncedEvent.Name != "" {
-					err := userStore.LoadUsersFromJSON()
-					StorageErrors.mu.Lock()
-					StorageErrors.Errors = []string{}
-					if err != nil {
-						errMsg := fmt.Sprintf("error reloading users: %s", err)
-						StorageErrors.Errors = append(StorageErrors.Errors, errMsg)
-						log.Println(errMsg)
-					}
-					StorageErrors.mu.Unlock()
-
-					debouncedEvent = fsnotify.Event{}
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Printf("watcher error: %s", err)
-			}
+	return nil
+}
+
+func (u *userStore[T]) DeleteUser(id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	source, ok := u.sources[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	if err := writeUser[T](source, nil); err != nil {
+		return err
+	}
+	delete(u.users, id)
+	delete(u.sources, id)
+
+	return nil
+}
+
+// validateUser checks required fields and that the username is not taken by another user,
+// same as LoadUsersFromJSON.
+func (u *userStore[T]) validateUser(user *T) error {
+	if (*user).ID() == "" {
+		return fmt.Errorf("%w: missing id", ErrInvalidUser)
+	}
+	if (*user).Username() == "" {
+		return fmt.Errorf("%w: missing username", ErrInvalidUser)
+	}
+	for id, other := range u.users {
+		if id != (*user).ID() && (*other).Username() == (*user).Username() {
+			return fmt.Errorf("%w: user with username %s already exists", ErrUserExists, (*user).Username())
 		}
-	}()
+	}
+
+	return nil
+}
+
+// writeUser replaces the user at source in its file, keeping other users as is.
+// The user is removed if nil.
+func writeUser[T User](source userSource, user *T) error {
+	users, err := readUsersFile(source.filePath)
+	if err != nil {
+		return err
+	}
 
-	err = filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+	if user == nil {
+		delete(users, source.key)
+	} else {
+		userData, err := json.Marshal(user)
 		if err != nil {
-			log.Printf("walkDir error: %s", err)
 			return err
 		}
-		if !d.IsDir() {
-			err = watcher.Add(path)
-			if err != nil {
-				log.Printf("watcher error: %s", err)
-				return err
-			}
-		}
-		return nil
-	})
+		users[source.key] = userData
+	}
+
+	data, err := json.MarshalIndent(users, "", "  ")
 	if err != nil {
-		log.Printf("walk error: %s", err)
+		return err
 	}
 
-	<-done
+	return os.WriteFile(source.filePath, append(data, '\n'), 0o644)
+}
+
+// readUsersFile returns the raw users of a users file by key. A missing file has none.
+func readUsersFile(filePath string) (map[string]json.RawMessage, error) {
+	users := map[string]json.RawMessage{}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &users); err != nil {
+			return nil, fmt.Errorf("invalid users in %s: %w", filePath, err)
+		}
+	}
+	return users, nil
 }
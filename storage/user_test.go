@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testUserWithEmail struct {
+	testUser
+	Email string
+}
+
+func (u testUserWithEmail) GetEmail() string { return u.Email }
+
+func TestFindUserByLoginHint(t *testing.T) {
+	users := map[string]*testUserWithEmail{
+		"alice": {testUser: testUsers["alice"], Email: "alice@example.com"},
+		"bob":   {testUser: testUsers["bob"]},
+		// the username of carol is the ID of alice, which takes precedence
+		"carol": {testUser: testUser{ID_: "carol-id", Username_: "alice-id"}, Email: "bob"},
+	}
+
+	tests := []struct {
+		name    string
+		hint    string
+		wantKey string
+	}{
+		{name: "empty", hint: ""},
+		{name: "id", hint: "bob-id", wantKey: "bob"},
+		{name: "username", hint: "bob", wantKey: "bob"},
+		{name: "username case-insensitive", hint: "BOB", wantKey: "bob"},
+		{name: "email", hint: "alice@example.com", wantKey: "alice"},
+		{name: "email case-insensitive", hint: "Alice@Example.com", wantKey: "alice"},
+		{name: "id before username", hint: "alice-id", wantKey: "alice"},
+		{name: "id is case-sensitive", hint: "CAROL-ID"},
+		{name: "unknown", hint: "dave"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, user := FindUserByLoginHint(users, tt.hint)
+			if key != tt.wantKey {
+				t.Fatalf("FindUserByLoginHint() key = %q, want %q", key, tt.wantKey)
+			}
+			if (user == nil) != (tt.wantKey == "") || (user != nil && user != users[tt.wantKey]) {
+				t.Errorf("FindUserByLoginHint() user = %v, want users[%q]", user, tt.wantKey)
+			}
+		})
+	}
+}
+
+// newTestUserStore creates a user store with testUsers in users.json of a new folder.
+func newTestUserStore(t *testing.T) (MutableUserStore[testUser], string) {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := json.Marshal(testUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, newUsersFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	userStore, err := NewUserStore[testUser]("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userStore.(MutableUserStore[testUser]), dir
+}
+
+// usersInFile returns the users of the users file by key.
+func usersInFile(t *testing.T, filePath string) map[string]testUser {
+	t.Helper()
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users map[string]testUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestMutableUserStore(t *testing.T) {
+	userStore, dir := newTestUserStore(t)
+	filePath := filepath.Join(dir, newUsersFile)
+
+	carol := testUser{ID_: "carol-id", Username_: "carol", Password_: "carol"}
+	if err := userStore.CreateUser(&carol); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if got := usersInFile(t, filePath)[carol.ID_]; got.Username_ != carol.Username_ {
+		t.Errorf("created user in %s = %+v, want %+v", newUsersFile, got, carol)
+	}
+	if len(usersInFile(t, filePath)) != len(testUsers)+1 {
+		t.Errorf("users in %s = %d, want %d", newUsersFile, len(usersInFile(t, filePath)), len(testUsers)+1)
+	}
+
+	tests := []struct {
+		name string
+		user testUser
+	}{
+		{name: "duplicate ID", user: testUser{ID_: "alice-id", Username_: "alice2"}},
+		{name: "duplicate username", user: testUser{ID_: "alice2-id", Username_: "alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := userStore.CreateUser(&tt.user); !errors.Is(err, ErrUserExists) {
+				t.Errorf("CreateUser() error = %v, want ErrUserExists", err)
+			}
+		})
+	}
+	if err := userStore.UpdateUser(&testUser{ID_: "carol-id", Username_: "alice"}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("UpdateUser() to a taken username: error = %v, want ErrUserExists", err)
+	}
+
+	// users are written back to the file and key they were loaded from
+	alice := testUsers["alice"]
+	alice.Password_ = "changed"
+	if err := userStore.UpdateUser(&alice); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	if got := usersInFile(t, filePath)["alice"]; got.Password_ != "changed" {
+		t.Errorf("updated user in %s = %+v, want password changed", newUsersFile, got)
+	}
+
+	if err := userStore.DeleteUser("unknown-id"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("DeleteUser() of unknown user: error = %v, want ErrUserNotFound", err)
+	}
+	if err := userStore.DeleteUser(carol.ID_); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	if _, ok := usersInFile(t, filePath)[carol.ID_]; ok || userStore.GetUserByID(carol.ID_) != nil {
+		t.Error("deleted user is still there")
+	}
+
+	reloaded, err := NewUserStore[testUser]("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := reloaded.GetUserByID(alice.ID_); user == nil || user.Password_ != "changed" {
+		t.Errorf("reloaded user = %+v, want password changed", user)
+	}
+	if reloaded.GetUserByID(carol.ID_) != nil {
+		t.Error("reloaded deleted user")
+	}
+}
+
+func TestUserStoreWatch(t *testing.T) {
+	userStore, dir := newTestUserStore(t)
+	carol := testUser{ID_: "carol-id", Username_: "carol", Password_: "carol"}
+	if err := userStore.CreateUser(&carol); err != nil {
+		t.Fatal(err)
+	}
+	dave := testUser{ID_: "dave-id", Username_: "dave", Password_: "dave"}
+	data, err := json.Marshal(map[string]testUser{"dave": dave})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the watcher starts in the background: the file is written until the change is seen
+	deadline := time.Now().Add(5 * time.Second)
+	for userStore.GetUserByID(dave.ID_) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("user added to the users folder was not loaded")
+		}
+		if err := os.WriteFile(filepath.Join(dir, "more_users.json"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if userStore.GetUserByID(testUsers["alice"].ID_) == nil || userStore.GetUserByID(carol.ID_) == nil {
+		t.Error("users of other files, including created ones, were not kept on reload")
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFolder calls reload whenever a file in dataDir is created or modified.
+// Errors are reported via StorageErrors under the given source name.
+func watchFolder(dataDir string, source string, reload func() error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
+
+	done := make(chan bool)
+	debounceTimer := time.NewTimer(0)  // Create a timer with no initial delay
+	debouncedEvent := fsnotify.Event{} // Stores the latest event to process
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+					log.Printf("file modified: %s", event.Name)
+
+					debouncedEvent = event // update the debounced event always to latest
+
+					if !debounceTimer.Stop() {
+						// timer has already expired, drain the channel
+						select {
+						case <-debounceTimer.C:
+						default:
+						}
+					}
+
+					debounceTimer.Reset(50 * time.Millisecond)
+				}
+			case <-debounceTimer.C:
+				if debouncedEvent.Name != "" {
+					err := reload()
+					if err != nil {
+						errMsg := fmt.Sprintf("error reloading %s: %s", source, err)
+						setStorageErrors(source, errMsg)
+						log.Println(errMsg)
+					} else {
+						setStorageErrors(source)
+					}
+
+					debouncedEvent = fsnotify.Event{}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("watcher error: %s", err)
+			}
+		}
+	}()
+
+	// the folder itself is watched for files created later, e.g. the users.json file of new users
+	if err := watcher.Add(dataDir); err != nil {
+		log.Printf("watcher error: %s", err)
+	}
+
+	err = filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("walkDir error: %s", err)
+			return err
+		}
+		if !d.IsDir() {
+			err = watcher.Add(path)
+			if err != nil {
+				log.Printf("watcher error: %s", err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("walk error: %s", err)
+	}
+
+	<-done
+}